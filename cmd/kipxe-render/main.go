@@ -0,0 +1,161 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/spf13/pflag"
+
+	"github.com/mandelsoft/kipxe/pkg/kipxe"
+	"github.com/mandelsoft/kipxe/pkg/manifests"
+)
+
+const usage = `kipxe-render renders the response of the kipxe server for a request
+based on kipxe manifests (BootProfileMatcher, BootProfile, BootResource,
+MetaDataMapper and referenced ConfigMaps and Secrets) read from files.
+No cluster access is required.
+
+Usage:
+  kipxe-render [flags] <resource path>
+
+Flags:
+`
+
+func main() {
+	var files []string
+	var query []string
+	var headers []string
+	var origin string
+	var cacheDir string
+	var logLevel string
+	var verbose bool
+	var trace bool
+
+	flags := pflag.NewFlagSet("kipxe-render", pflag.ContinueOnError)
+	flags.StringArrayVarP(&files, "file", "f", nil, "manifest file or directory (may be given multiple times)")
+	flags.StringArrayVarP(&query, "query", "q", nil, "query parameter <name>=<value> (may be given multiple times)")
+	flags.StringArrayVarP(&headers, "header", "H", nil, "request header <name>: <value> (may be given multiple times)")
+	flags.StringVarP(&origin, "origin", "o", "127.0.0.1", "origin address of the request")
+	flags.StringVar(&cacheDir, "cache-dir", "", "enable URL caching in a dedicated directory")
+	flags.StringVarP(&logLevel, "log-level", "D", "warning", "log level")
+	flags.BoolVarP(&verbose, "verbose", "v", false, "print response status and headers to stderr")
+	flags.BoolVar(&trace, "trace-requests", false, "trace mapping of request data")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		if err == pflag.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+	if flags.NArg() != 1 || len(files) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if err := logger.SetLevel(logLevel); err != nil {
+		fail("invalid log level: %s", err)
+	}
+	kipxe.Trace(trace)
+
+	m := manifests.New()
+	for _, f := range files {
+		if err := m.Load(f); err != nil {
+			fail("cannot load manifests: %s", err)
+		}
+	}
+
+	var cache kipxe.Cache
+	if cacheDir != "" {
+		path, err := filepath.Abs(cacheDir)
+		if err != nil {
+			fail("invalid cache dir: %s", err)
+		}
+		cache, err = kipxe.NewDirectoryCache(logger.New(), path)
+		if err != nil {
+			fail("cannot create cache: %s", err)
+		}
+	}
+	infobase, err := m.InfoBase(cache)
+	if err != nil {
+		fail("invalid manifests:\n%s", err)
+	}
+
+	values := url.Values{}
+	for _, q := range query {
+		i := strings.Index(q, "=")
+		if i <= 0 {
+			fail("invalid query parameter %q", q)
+		}
+		values.Add(q[:i], q[i+1:])
+	}
+	target := "/" + strings.TrimPrefix(flags.Arg(0), "/")
+	if len(values) > 0 {
+		target += "?" + values.Encode()
+	}
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.RemoteAddr = origin + ":0"
+	for _, h := range headers {
+		i := strings.Index(h, ":")
+		if i <= 0 {
+			fail("invalid header %q", h)
+		}
+		req.Header.Add(strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+	}
+
+	w := httptest.NewRecorder()
+	kipxe.NewHandler(logger.New(), "/", infobase).ServeHTTP(w, req)
+
+	if verbose {
+		fmt.Fprintf(os.Stderr, "%d %s\n", w.Code, http.StatusText(w.Code))
+		keys := []string{}
+		for k := range w.Header() {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", k, strings.Join(w.Header()[k], ", "))
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+	if w.Code >= 300 {
+		if !verbose {
+			fmt.Fprintf(os.Stderr, "%d %s: ", w.Code, http.StatusText(w.Code))
+		}
+		os.Stderr.Write(w.Body.Bytes())
+		os.Exit(1)
+	}
+	os.Stdout.Write(w.Body.Bytes())
+}
+
+func fail(msg string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "Error: "+msg+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/mandelsoft/kipxe/pkg/kipxe"
+	"github.com/mandelsoft/kipxe/pkg/manifests"
+	"github.com/mandelsoft/kipxe/pkg/metrics"
+)
+
+const usage = `kipxe-standalone runs the kipxe HTTP server without a Kubernetes cluster.
+The kipxe manifests (BootProfileMatcher, BootProfile, BootResource,
+MetaDataMapper and referenced ConfigMaps and Secrets) are read from files
+or directories, which are watched for changes.
+
+Usage:
+  kipxe-standalone [flags]
+
+Flags:
+`
+
+func main() {
+	var files []string
+	var address string
+	var basePath string
+	var cacheDir string
+	var cacheTTL time.Duration
+	var compression string
+	var explainToken string
+	var logLevel string
+	var disableCompression bool
+	var noWatch bool
+	var trace bool
+
+	flags := pflag.NewFlagSet("kipxe-standalone", pflag.ContinueOnError)
+	flags.StringArrayVarP(&files, "file", "f", nil, "manifest file or directory (may be given multiple times)")
+	flags.StringVar(&address, "bind-address", ":8081", "HTTP server bind address")
+	flags.StringVar(&basePath, "base-path", "/", "pxe server URL base path")
+	flags.StringVar(&cacheDir, "cache-dir", "", "enable URL caching in a dedicated directory")
+	flags.DurationVar(&cacheTTL, "cache-ttl", 10*time.Minute, "TTL for cache entries")
+	flags.BoolVar(&disableCompression, "disable-compression", false, "disable compression of text responses")
+	flags.StringVar(&compression, "compression-threshold", "1Ki", "minimum size of text responses to be compressed")
+	flags.StringVar(&explainToken, "explain-token", "", "bearer token enabling the explain endpoint")
+	flags.BoolVar(&noWatch, "no-watch", false, "do not watch the manifests for changes")
+	flags.StringVarP(&logLevel, "log-level", "D", "info", "log level")
+	flags.BoolVar(&trace, "trace-requests", false, "trace mapping of request data")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		if err == pflag.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+	if flags.NArg() != 0 || len(files) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if err := logger.SetLevel(logLevel); err != nil {
+		fail("invalid log level: %s", err)
+	}
+	kipxe.Trace(trace)
+	log := logger.New()
+
+	threshold, err := resource.ParseQuantity(compression)
+	if err != nil {
+		fail("invalid compression threshold: %s", err)
+	}
+	if !strings.HasPrefix(basePath, "/") {
+		basePath = "/" + basePath
+	}
+
+	var cache kipxe.Cache
+	var dircache *kipxe.DirCache
+	if cacheDir != "" {
+		dir, err := filepath.Abs(cacheDir)
+		if err != nil {
+			fail("invalid cache dir: %s", err)
+		}
+		dircache, err = kipxe.NewDirectoryCache(log, dir)
+		if err != nil {
+			fail("cannot create cache: %s", err)
+		}
+		cache = dircache
+	}
+
+	backend, err := manifests.NewBackend(log, cache, files...)
+	if backend == nil {
+		fail("%s", err)
+	}
+	if err != nil {
+		log.Errorf("invalid manifests:\n%s", err)
+	}
+	infobase := backend.InfoBase()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	if !noWatch {
+		go func() {
+			if err := backend.Watch(ctx); err != nil {
+				log.Errorf("cannot watch manifests: %s", err)
+			}
+		}()
+	}
+	if dircache != nil {
+		go func() {
+			ticker := time.NewTicker(time.Minute)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					dircache.Cleanup(log, cacheTTL)
+				}
+			}
+		}()
+	}
+
+	mux := http.NewServeMux()
+	var handler http.Handler = kipxe.NewHandler(log, basePath, infobase)
+	if !disableCompression {
+		handler = kipxe.NewCompressionHandler(handler, threshold.Value())
+	}
+	mux.Handle(basePath, handler)
+	mux.Handle("/metrics", metrics.Handler())
+	if explainToken != "" {
+		explain := path.Join(basePath, kipxe.PATH_EXPLAIN)
+		mux.Handle(explain+"/", kipxe.NewExplainHandler(log, explain, infobase, explainToken))
+	}
+
+	server := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		<-sig
+		log.Infof("shutting down")
+		cancel()
+		shutdown, done := context.WithTimeout(context.Background(), 10*time.Second)
+		defer done()
+		server.Shutdown(shutdown)
+	}()
+
+	log.Infof("serving %s on %s", basePath, address)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fail("%s", err)
+	}
+}
+
+func fail(msg string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "Error: "+msg+"\n", args...)
+	os.Exit(1)
+}
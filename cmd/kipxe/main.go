@@ -23,9 +23,9 @@ import (
 
 	_ "github.com/gardener/controller-manager-library/pkg/resources/defaultscheme/v1.16"
 
-	_ "github.com/onmetal/k8s-machines/pkg/controllers/machines"
-
 	_ "github.com/mandelsoft/kipxe/pkg/controllers/ipxe"
+	_ "github.com/mandelsoft/kipxe/pkg/controllers/machineinfos"
+	_ "github.com/mandelsoft/kipxe/pkg/controllers/machines"
 )
 
 func main() {
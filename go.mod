@@ -5,13 +5,16 @@ go 1.14
 require (
 	github.com/ahmetb/gen-crd-api-reference-docs v0.2.0
 	github.com/emicklei/go-restful v2.9.5+incompatible
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/gardener/controller-manager-library v0.2.1-0.20201210150244-e1773318c71b
 	github.com/ghodss/yaml v1.0.0
+	github.com/klauspost/compress v1.11.13
 	github.com/mandelsoft/spiff v1.3.0-beta-7.0.20201204102559-2c5ea71bbbbf
 	github.com/mandelsoft/vfs v0.0.0-20201002134249-3c471f64a4d1 // indirect
 	github.com/onmetal/k8s-machines v0.0.0-20201211120911-4762a434cf88
 	github.com/onsi/ginkgo v1.14.0
 	github.com/onsi/gomega v1.10.1
+	github.com/prometheus/client_golang v1.0.0
 	github.com/spf13/cobra v0.0.6 // indirect
 	github.com/spf13/pflag v1.0.5
 	golang.org/x/lint v0.0.0-20190313153728-d0100b6bd8b3
@@ -264,6 +264,10 @@ spec:
     - jsonPath: .status.state
       name: State
       type: string
+    - jsonPath: .status.cache.state
+      name: Cache
+      priority: 2000
+      type: string
     name: v1alpha1
     schema:
       openAPIV3Schema:
@@ -282,8 +286,31 @@ spec:
                 type: string
               binary:
                 type: string
+              cachePolicy:
+                description: CachePolicy controls the revalidation of cached URL content.
+                properties:
+                  maxAge:
+                    description: MaxAge is the period cached content is considered fresh
+                    type: string
+                  staleIfError:
+                    description: StaleIfError is the period after MaxAge stale content is served if the revalidation fails
+                    type: string
+                  staleWhileRevalidate:
+                    description: StaleWhileRevalidate is the period after MaxAge stale content is served while it is revalidated in the background
+                    type: string
+                type: object
               configMap:
                 type: string
+              digest:
+                description: Digest describes the expected (hex encoded) digests of the content of a URL, ConfigMap or Secret resource.
+                properties:
+                  sha256:
+                    pattern: ^[0-9a-fA-F]{64}$
+                    type: string
+                  sha512:
+                    pattern: ^[0-9a-fA-F]{128}$
+                    type: string
+                type: object
               fieldName:
                 type: string
               mapping:
@@ -294,6 +321,9 @@ spec:
                 type: string
               plainContent:
                 type: boolean
+              prefetch:
+                description: Prefetch fills the cache for a static URL in advance
+                type: boolean
               redirect:
                 type: boolean
               secret:
@@ -309,6 +339,28 @@ spec:
             type: object
           status:
             properties:
+              cache:
+                description: Cache reports the state of the prefetched URL content
+                properties:
+                  URL:
+                    description: URL is the prefetched URL
+                    type: string
+                  digest:
+                    description: Digest is the sha256 digest of the cached content
+                    type: string
+                  message:
+                    description: Message describes a prefetch error
+                    type: string
+                  size:
+                    description: Size is the size of the cached content
+                    format: int64
+                    type: integer
+                  state:
+                    description: State is one of Fetching, Cached or Failed
+                    type: string
+                required:
+                - state
+                type: object
               message:
                 type: string
               state:
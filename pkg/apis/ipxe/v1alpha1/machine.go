@@ -23,6 +23,10 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// LABEL_DISCOVERED marks machines created by the discovery of unknown
+// machines. Removing it approves the machine.
+const LABEL_DISCOVERED = GroupName + "/discovered"
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 type MachineList struct {
@@ -25,6 +25,8 @@ import (
 
 const STATE_READY = "Ready"
 const STATE_INVALID = "Invalid"
+const STATE_CONFLICT = "Conflict"
+const STATE_DISCOVERED = "Discovered"
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
@@ -45,6 +45,7 @@ type BootResourceList struct {
 // +kubebuilder:printcolumn:name=Secret,JSONPath=".spec.secret",priority=2000,type=string
 // +kubebuilder:printcolumn:name=Field,JSONPath=".spec.fieldName",priority=2000,type=string
 // +kubebuilder:printcolumn:name=State,JSONPath=".status.state",type=string
+// +kubebuilder:printcolumn:name=Cache,JSONPath=".status.cache.state",priority=2000,type=string
 // +genclient
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
@@ -73,6 +74,9 @@ type BootResourceSpec struct {
 	URL string `json:"URL,omitempty"`
 	// +optional
 	Volatile bool `json:"volatile,omitempty"`
+	// Prefetch fills the cache for a static URL in advance
+	// +optional
+	Prefetch bool `json:"prefetch,omitempty"`
 	// +optional
 	Redirect *bool `json:"redirect,omitempty"`
 	// +optional
@@ -86,6 +90,37 @@ type BootResourceSpec struct {
 	Secret string `json:"secret,omitempty"`
 	// +optional
 	FieldName string `json:"fieldName,omitempty"`
+
+	// +optional
+	CachePolicy *CachePolicy `json:"cachePolicy,omitempty"`
+	// +optional
+	Digest *Digest `json:"digest,omitempty"`
+}
+
+// Digest describes the expected (hex encoded) digests of the content
+// of a URL, ConfigMap or Secret resource.
+type Digest struct {
+	// +kubebuilder:validation:Pattern=^[0-9a-fA-F]{64}$
+	// +optional
+	SHA256 string `json:"sha256,omitempty"`
+	// +kubebuilder:validation:Pattern=^[0-9a-fA-F]{128}$
+	// +optional
+	SHA512 string `json:"sha512,omitempty"`
+}
+
+// CachePolicy controls the revalidation of cached URL content.
+type CachePolicy struct {
+	// MaxAge is the period cached content is considered fresh
+	// +optional
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
+	// StaleWhileRevalidate is the period after MaxAge stale content
+	// is served while it is revalidated in the background
+	// +optional
+	StaleWhileRevalidate *metav1.Duration `json:"staleWhileRevalidate,omitempty"`
+	// StaleIfError is the period after MaxAge stale content
+	// is served if the revalidation fails
+	// +optional
+	StaleIfError *metav1.Duration `json:"staleIfError,omitempty"`
 }
 
 type BootResourceStatus struct {
@@ -94,4 +129,30 @@ type BootResourceStatus struct {
 
 	// +optional
 	Message string `json:"message,omitempty"`
+
+	// Cache reports the state of the prefetched URL content
+	// +optional
+	Cache *CacheStatus `json:"cache,omitempty"`
+}
+
+const CACHE_FETCHING = "Fetching"
+const CACHE_CACHED = "Cached"
+const CACHE_FAILED = "Failed"
+
+// CacheStatus describes the state of prefetched URL content.
+type CacheStatus struct {
+	// State is one of Fetching, Cached or Failed
+	State string `json:"state"`
+	// URL is the prefetched URL
+	// +optional
+	URL string `json:"URL,omitempty"`
+	// Size is the size of the cached content
+	// +optional
+	Size int64 `json:"size,omitempty"`
+	// Digest is the sha256 digest of the cached content
+	// +optional
+	Digest string `json:"digest,omitempty"`
+	// Message describes a prefetch error
+	// +optional
+	Message string `json:"message,omitempty"`
 }
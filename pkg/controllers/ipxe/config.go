@@ -20,11 +20,16 @@ package ipxe
 
 import (
 	"fmt"
+	"net"
 	"strings"
 	"time"
 
 	"github.com/gardener/controller-manager-library/pkg/config"
 	"github.com/gardener/controller-manager-library/pkg/controllermanager/cert"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/mandelsoft/kipxe/pkg/indexmapper"
+	"github.com/mandelsoft/kipxe/pkg/kipxe"
 )
 
 const CERT_NONE = "none"
@@ -36,10 +41,36 @@ type Config struct {
 
 	LocalNamespaceOnly bool
 	PXEPort            int
+	TFTPPort           int
+	ProxyDHCP          bool
+	ProxyDHCPAddress   string
+	ProxyDHCPPath      string
 	CacheDir           string
 	CacheTTL           time.Duration
+	CacheMaxSize       string
+	CacheMaxEntrySize  string
+	CachePolicy        kipxe.CachePolicy
+
+	cacheMaxSize      int64
+	cacheMaxEntrySize int64
+
+	DisableCompression   bool
+	CompressionThreshold string
+	CachePrecompression  string
+	compressionThreshold int64
+	cachePrecompression  int64
+
+	RenderCacheSize string
+	renderCacheSize int64
 
 	TraceRequest bool
+	ExplainToken string
+
+	CacheAdminToken string
+	InventoryToken  string
+
+	MachineLookupKeys []string
+	machineLookupKeys []indexmapper.LookupKey
 
 	CertMode string
 	TLS      bool
@@ -51,10 +82,27 @@ func (this *Config) AddOptionsToSet(set config.OptionSet) {
 	this.set = set
 	set.AddStringOption(&this.CacheDir, "cache-dir", "", "", "enable URL caching in a dedicated directory")
 	set.AddDurationOption(&this.CacheTTL, "cache-ttl", "", 10*time.Minute, "TTL for cache entries")
+	set.AddStringOption(&this.CacheMaxSize, "cache-max-size", "", "", "maximum size of the URL cache (for example 10Gi)")
+	set.AddStringOption(&this.CacheMaxEntrySize, "cache-max-entry-size", "", "", "maximum size of a single URL cache entry")
+	set.AddDurationOption(&this.CachePolicy.MaxAge, "cache-max-age", "", 0, "default period cached URL content is considered fresh (0 never revalidates)")
+	set.AddDurationOption(&this.CachePolicy.StaleWhileRevalidate, "cache-stale-while-revalidate", "", 0, "default period stale URL content is served while revalidating")
+	set.AddDurationOption(&this.CachePolicy.StaleIfError, "cache-stale-if-error", "", 0, "default period stale URL content is served if revalidation fails")
+	set.AddBoolOption(&this.DisableCompression, "disable-compression", "", false, "disable compression of text responses")
+	set.AddStringOption(&this.CompressionThreshold, "compression-threshold", "", "1Ki", "minimum size of text responses to be compressed")
+	set.AddStringOption(&this.CachePrecompression, "cache-precompression-threshold", "", "", "minimum size of cached text content to keep a precompressed variant for")
+	set.AddStringOption(&this.RenderCacheSize, "render-cache-size", "", "", "maximum size of memoized rendered documents (disabled if not set)")
 	set.AddBoolOption(&this.LocalNamespaceOnly, "local-namespace-only", "", false, "server only resources in local namespace")
 	set.AddBoolOption(&this.TraceRequest, "trace-requests", "", false, "trace mapping of request data")
+	set.AddStringOption(&this.ExplainToken, "explain-token", "", "", "bearer token enabling the explain endpoint")
+	set.AddStringOption(&this.CacheAdminToken, "cache-admin-token", "", "", "bearer token enabling the cache administration endpoint")
+	set.AddStringOption(&this.InventoryToken, "inventory-token", "", "", "bearer token enabling the hardware inventory endpoint")
+	set.AddStringArrayOption(&this.MachineLookupKeys, "machine-lookup-keys", "", indexmapper.DefaultLookupKeys, "keys (<key>[=<metadata field>]) used in this order to look up machines in the machine index")
 	set.AddIntOption(&this.PXEPort, "pxe-port", "", 8081, "pxe server port")
 	set.AddStringOption(&this.BasePath, "base-path", "", "", "pxe server URL base path")
+	set.AddIntOption(&this.TFTPPort, "tftp-port", "", 0, "tftp server port (0 disables the tftp server)")
+	set.AddBoolOption(&this.ProxyDHCP, "proxy-dhcp", "", false, "enable proxy dhcp server for PXE clients")
+	set.AddStringOption(&this.ProxyDHCPAddress, "proxy-dhcp-address", "", "", "server address announced by the proxy dhcp server")
+	set.AddStringOption(&this.ProxyDHCPPath, "proxy-dhcp-path", "", kipxe.DHCP_RESOURCE_PATH, "resource path used to determine the boot file for proxy dhcp requests")
 
 	set.AddBoolOption(&this.TLS, "use-tls", "", false, "use https")
 	set.AddStringOption(&this.CertMode, "certificate-mode", "", "manage", "mode for cert management")
@@ -70,6 +118,39 @@ func (this *Config) Prepare() error {
 			this.BasePath = "/" + this.BasePath
 		}
 	}
+	var err error
+	this.cacheMaxSize, err = parseSize("cache-max-size", this.CacheMaxSize)
+	if err != nil {
+		return err
+	}
+	this.cacheMaxEntrySize, err = parseSize("cache-max-entry-size", this.CacheMaxEntrySize)
+	if err != nil {
+		return err
+	}
+	this.compressionThreshold, err = parseSize("compression-threshold", this.CompressionThreshold)
+	if err != nil {
+		return err
+	}
+	this.cachePrecompression, err = parseSize("cache-precompression-threshold", this.CachePrecompression)
+	if err != nil {
+		return err
+	}
+	this.renderCacheSize, err = parseSize("render-cache-size", this.RenderCacheSize)
+	if err != nil {
+		return err
+	}
+	this.machineLookupKeys, err = indexmapper.ParseLookupKeys(this.MachineLookupKeys)
+	if err != nil {
+		return err
+	}
+	if this.ProxyDHCP {
+		if this.ProxyDHCPAddress == "" {
+			return fmt.Errorf("server address required for proxy dhcp")
+		}
+		if ip := net.ParseIP(this.ProxyDHCPAddress); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("invalid IPv4 address %q for proxy dhcp", this.ProxyDHCPAddress)
+		}
+	}
 	if this.TLS {
 		if this.set != nil {
 			opt := this.set.GetOption("pxe-port")
@@ -102,3 +183,17 @@ func (this *Config) Prepare() error {
 
 	return nil
 }
+
+func parseSize(name, value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %s", name, value, err)
+	}
+	if q.Sign() < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must not be negative", name, value)
+	}
+	return q.Value(), nil
+}
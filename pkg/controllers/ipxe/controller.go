@@ -26,11 +26,13 @@ import (
 	"github.com/gardener/controller-manager-library/pkg/controllermanager/controller/reconcile"
 	"github.com/gardener/controller-manager-library/pkg/resources"
 	"github.com/gardener/controller-manager-library/pkg/resources/apiextensions"
+	"github.com/gardener/controller-manager-library/pkg/server"
 	_apps "k8s.io/api/apps/v1"
 
 	"github.com/mandelsoft/kipxe/pkg/apis/ipxe/crds"
 	api "github.com/mandelsoft/kipxe/pkg/apis/ipxe/v1alpha1"
 	"github.com/mandelsoft/kipxe/pkg/kipxe"
+	"github.com/mandelsoft/kipxe/pkg/metrics"
 )
 
 const NAME = "ipxe"
@@ -43,6 +45,10 @@ func init() {
 	crds.AddToRegistry(apiextensions.DefaultRegistry())
 }
 
+func init() {
+	server.RegisterHandler("/metrics", metrics.Handler())
+}
+
 func init() {
 	_ = _apps.Deployment{}
 	controller.Configure(NAME).
@@ -74,6 +80,9 @@ func Create(controller controller.Interface) (reconcile.Interface, error) {
 		if err != nil {
 			return nil, err
 		}
+		cache.SetQuota(config.cacheMaxSize, config.cacheMaxEntrySize)
+		cache.SetPolicy(config.CachePolicy)
+		cache.SetPrecompression(config.cachePrecompression)
 	}
 
 	if config.TLS {
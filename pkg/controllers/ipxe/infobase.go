@@ -57,6 +57,14 @@ func NewInfoBase(controller controller.Interface) *InfoBase {
 	return b
 }
 
+// Cache provides the URL cache, or nil if no cache is configured.
+func (this *InfoBase) Cache() kipxe.Cache {
+	if this.cache == nil {
+		return nil
+	}
+	return this.cache
+}
+
 func (this *InfoBase) Setup() {
 	this.resources.Setup(this.controller)
 	this.profiles.Setup(this.controller)
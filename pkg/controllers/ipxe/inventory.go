@@ -0,0 +1,122 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package ipxe
+
+import (
+	"net/http"
+	"reflect"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/gardener/controller-manager-library/pkg/resources"
+	"github.com/gardener/controller-manager-library/pkg/types/infodata/simple"
+	mapi "github.com/onmetal/k8s-machines/pkg/apis/machines/v1alpha1"
+	"github.com/onmetal/k8s-machines/pkg/machines"
+
+	"github.com/mandelsoft/kipxe/pkg/indexmapper"
+	"github.com/mandelsoft/kipxe/pkg/kipxe"
+)
+
+// INVENTORY_FIELD is the field of the machine info values
+// the complete inventory is stored in.
+const INVENTORY_FIELD = "inventory"
+
+// InventoryStore stores inventories on the MachineInfo resources
+// found in the machine index. The complete inventory is kept in the
+// values, only NICs with unknown MAC addresses are added to the spec.
+type InventoryStore struct {
+	index    *indexmapper.Indexer
+	resource resources.Interface
+}
+
+var _ kipxe.InventoryStore = &InventoryStore{}
+
+func NewInventoryStore(index *indexmapper.Indexer, resource resources.Interface) *InventoryStore {
+	return &InventoryStore{
+		index:    index,
+		resource: resource,
+	}
+}
+
+func (this *InventoryStore) lookup(inventory *kipxe.Inventory) *machines.Machine {
+	if inventory.UUID != "" {
+		if m := this.index.GetByUUID(inventory.UUID); m != nil {
+			return m
+		}
+	}
+	for _, n := range inventory.NICs {
+		if m := this.index.GetByMAC(n.MAC); m != nil {
+			return m
+		}
+	}
+	return nil
+}
+
+func (this *InventoryStore) StoreInventory(logger logger.LogContext, inventory *kipxe.Inventory) (string, error) {
+	m := this.lookup(inventory)
+	if m == nil {
+		return "", kipxe.NewStatusError(http.StatusNotFound, "no machine found for inventory")
+	}
+	values, err := inventory.Values()
+	if err != nil {
+		return "", err
+	}
+	_, _, err = this.resource.ModifyByName(resources.NewObjectName(m.Name.Namespace(), m.Name.Name()), func(data resources.ObjectData) (bool, error) {
+		mi := data.(*mapi.MachineInfo)
+		mod := false
+		if nics := mergeNICs(mi.Spec.NICs, inventory.NICs); nics != nil {
+			mi.Spec.NICs = nics
+			mod = true
+		}
+		if mi.Spec.Values.Values == nil {
+			mi.Spec.Values.Values = simple.Values{}
+		}
+		if !reflect.DeepEqual(mi.Spec.Values.Values[INVENTORY_FIELD], values) {
+			mi.Spec.Values.Values[INVENTORY_FIELD] = values
+			mod = true
+		}
+		return mod, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	logger.Infof("inventory of %s updated", m.Name)
+	return m.Name.String(), nil
+}
+
+// mergeNICs adds the reported NICs with MAC addresses not yet known to the
+// NICs of a machine. Existing NICs are never modified or removed, because
+// they are used to identify the machine, and a ramdisk might not report
+// all NICs. It returns nil if there are no new NICs.
+func mergeNICs(nics []mapi.NIC, reported []kipxe.InventoryNIC) []mapi.NIC {
+	known := map[string]bool{}
+	for _, n := range nics {
+		known[indexmapper.Normalize(indexmapper.KEY_MAC, n.MAC)] = true
+	}
+	var result []mapi.NIC
+	for _, n := range reported {
+		if !known[n.MAC] {
+			known[n.MAC] = true
+			if result == nil {
+				result = append(result, nics...)
+			}
+			result = append(result, mapi.NIC{Name: n.Name, MAC: n.MAC, Bandwidth: n.Speed})
+		}
+	}
+	return result
+}
@@ -124,7 +124,7 @@ func NewProfile(m *v1alpha1.BootProfile) (*kipxe.BootProfile, error) {
 			}
 			d, err = kipxe.NewDeliverableByPattern(resources.NewObjectName(m.Namespace, r.DocumentName), r.Pattern)
 			if err != nil {
-				return nil, fmt.Errorf("entry %d: invalid path pattern", i, err)
+				return nil, fmt.Errorf("entry %d: invalid path pattern: %s", i, err)
 			}
 		}
 		deliverables = append(deliverables, d)
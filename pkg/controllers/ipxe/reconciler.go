@@ -19,6 +19,7 @@
 package ipxe
 
 import (
+	"net"
 	"path"
 	"time"
 
@@ -29,14 +30,15 @@ import (
 	"github.com/gardener/controller-manager-library/pkg/logger"
 	"github.com/gardener/controller-manager-library/pkg/resources"
 	"github.com/gardener/controller-manager-library/pkg/server"
+	mapi "github.com/onmetal/k8s-machines/pkg/apis/machines/v1alpha1"
 
 	"github.com/mandelsoft/kipxe/pkg/apis/ipxe/v1alpha1"
 	"github.com/mandelsoft/kipxe/pkg/controllers"
 	"github.com/mandelsoft/kipxe/pkg/controllers/ipxe/ready"
+	"github.com/mandelsoft/kipxe/pkg/dhcp"
 	"github.com/mandelsoft/kipxe/pkg/indexmapper"
 	"github.com/mandelsoft/kipxe/pkg/kipxe"
-
-	mach "github.com/onmetal/k8s-machines/pkg/controllers"
+	"github.com/mandelsoft/kipxe/pkg/tftp"
 )
 
 type Ready struct{}
@@ -78,12 +80,41 @@ func (this *reconciler) Start() {
 		Matchers:  this.infobase.matchers.elements,
 	}
 
-	indexer := mach.GetMachineIndex(this.controller.GetEnvironment())
+	indexer := controllers.GetMachineIndex(this.controller.GetEnvironment())
 	if indexer != nil {
-		infobase.Registry.Register(indexmapper.NewIndexMapper(indexer, 100))
+		logger.Infof("machine lookup keys: %v", this.config.machineLookupKeys)
+		infobase.Registry.Register(indexmapper.NewIndexMapper(indexer, 100, this.config.machineLookupKeys...))
+	}
+	if this.config.renderCacheSize > 0 {
+		logger.Infof("memoizing rendered documents up to %d bytes", this.config.renderCacheSize)
+		infobase.Resources.SetRenderCache(kipxe.NewRenderCache(this.config.renderCacheSize))
+	}
+	handler := kipxe.NewHandler(this.controller, this.config.BasePath, infobase)
+	if !this.config.DisableCompression {
+		handler = kipxe.NewCompressionHandler(handler, this.config.compressionThreshold)
+	}
+	ipxe.RegisterHandler(this.config.BasePath, handler)
+	ipxe.Register(path.Join(this.config.BasePath, kipxe.PATH_READY), ready.Ready)
+	if this.config.ExplainToken != "" {
+		explain := path.Join(this.config.BasePath, kipxe.PATH_EXPLAIN)
+		ipxe.RegisterHandler(explain+"/", kipxe.NewExplainHandler(this.controller, explain, infobase, this.config.ExplainToken))
+	}
+	if this.config.CacheAdminToken != "" && this.infobase.cache != nil {
+		admin := path.Join(this.config.BasePath, kipxe.PATH_CACHE)
+		ipxe.RegisterHandler(admin+"/", kipxe.NewCacheAdminHandler(this.controller, admin, this.infobase.cache, this.config.CacheAdminToken))
+	}
+	if this.config.InventoryToken != "" {
+		if indexer == nil {
+			logger.Warnf("inventory endpoint requires the machine index (controller machineinfos)")
+		} else {
+			resc, err := this.controller.GetMainCluster().Resources().Get(&mapi.MachineInfo{})
+			if err != nil {
+				panic(err)
+			}
+			store := NewInventoryStore(indexer, resc)
+			ipxe.RegisterHandler(path.Join(this.config.BasePath, kipxe.PATH_INVENTORY), kipxe.NewInventoryHandler(this.controller, store, this.config.InventoryToken))
+		}
 	}
-	ipxe.RegisterHandler(this.config.BasePath, kipxe.NewHandler(this.controller, this.config.BasePath, infobase))
-	ipxe.Register(path.Join(this.config.BasePath, "ready"), ready.Ready)
 
 	cert := this.cert
 	if !this.config.TLS {
@@ -93,6 +124,21 @@ func (this *reconciler) Start() {
 		infobase.Registry.Register(NewCertMapper(this))
 	}
 	ipxe.Start(cert, "", this.config.PXEPort)
+
+	if this.config.TFTPPort > 0 {
+		tftpserver := tftp.NewServer(this.controller.GetContext(), this.controller.NewContext("server", "tftp"), "tftp", kipxe.NewTFTPHandler(infobase))
+		if err := tftpserver.Start("", this.config.TFTPPort); err != nil {
+			this.controller.Errorf("cannot start tftp server: %s", err)
+		}
+	}
+
+	if this.config.ProxyDHCP {
+		handler := kipxe.NewDHCPHandler(infobase, this.config.ProxyDHCPPath)
+		proxy := dhcp.NewProxyServer(this.controller.GetContext(), this.controller.NewContext("server", "proxydhcp"), "proxydhcp", net.ParseIP(this.config.ProxyDHCPAddress), handler)
+		if err := proxy.Start(""); err != nil {
+			this.controller.Errorf("cannot start proxy dhcp server: %s", err)
+		}
+	}
 	go func() {
 		time.Sleep(2 * time.Second)
 		ready.Register(&Ready{})
@@ -141,6 +187,8 @@ func (this *reconciler) Deleted(logger logger.LogContext, key resources.ClusterO
 }
 
 func (this *reconciler) Command(logger logger.LogContext, cmd string) reconcile.Status {
-	this.infobase.cache.Cleanup(logger, this.config.CacheTTL)
+	if this.infobase.cache != nil {
+		this.infobase.cache.Cleanup(logger, this.config.CacheTTL)
+	}
 	return reconcile.Succeeded(logger)
 }
@@ -22,7 +22,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"reflect"
 	"strings"
+	"sync"
 	"text/template"
 
 	"github.com/gardener/controller-manager-library/pkg/logger"
@@ -41,12 +44,16 @@ import (
 type BootResources struct {
 	ResourceCache
 	elements *kipxe.BootResources
+
+	lock        sync.Mutex
+	prefetching map[resources.ObjectName]bool
 }
 
 func newResources(infobase *InfoBase) *BootResources {
 	return &BootResources{
 		ResourceCache: NewResourceCache(infobase, &v1alpha1.BootResource{}),
 		elements:      kipxe.NewResources(),
+		prefetching:   map[resources.ObjectName]bool{},
 	}
 }
 
@@ -82,7 +89,7 @@ func (this *BootResources) Recheck(users kipxe.NameSet) {
 }
 
 func (this *BootResources) Update(logger logger.LogContext, obj resources.Object) (*kipxe.BootResource, error) {
-	m, err := NewResource(obj, this.InfoBase.cache)
+	m, err := NewResource(obj, this.InfoBase.Cache())
 	if err == nil {
 		this.recheckUsers(logger, this.elements.Set(m))
 	}
@@ -103,9 +110,87 @@ func (this *BootResources) Update(logger logger.LogContext, obj resources.Object
 		mod.AssureStringValue(&m.Status.Message, "document ok")
 		return nil
 	})
+	if err == nil {
+		this.prefetch(logger, obj, m)
+	}
 	return m, err
 }
 
+// prefetch asynchronously fills the cache for resources requesting
+// a prefetch of their URL. The result is reported in the status.
+func (this *BootResources) prefetch(logger logger.LogContext, obj resources.Object, elem *kipxe.BootResource) {
+	r := obj.Data().(*v1alpha1.BootResource)
+	if !r.Spec.Prefetch {
+		if r.Status.Cache != nil {
+			this.updateCacheStatus(logger, obj, nil)
+		}
+		return
+	}
+	src := elem.GetSource().(kipxe.URLSource)
+	if src.Cache() == nil {
+		this.updateCacheStatus(logger, obj, &v1alpha1.CacheStatus{
+			State:   v1alpha1.CACHE_FAILED,
+			URL:     src.URL(),
+			Message: "no cache configured",
+		})
+		return
+	}
+	u, err := url.Parse(src.URL())
+	if err != nil {
+		return
+	}
+
+	name := obj.ObjectName()
+	this.lock.Lock()
+	if this.prefetching[name] {
+		this.lock.Unlock()
+		return
+	}
+	this.prefetching[name] = true
+	this.lock.Unlock()
+
+	if r.Status.Cache == nil || r.Status.Cache.URL != src.URL() {
+		this.updateCacheStatus(logger, obj, &v1alpha1.CacheStatus{
+			State: v1alpha1.CACHE_FETCHING,
+			URL:   src.URL(),
+		})
+	}
+	go func() {
+		defer func() {
+			this.lock.Lock()
+			delete(this.prefetching, name)
+			this.lock.Unlock()
+		}()
+		logger.Infof("prefetching %s", src.URL())
+		status := &v1alpha1.CacheStatus{URL: src.URL()}
+		info, err := src.Cache().Prefetch(u)
+		if err != nil {
+			logger.Warnf("prefetch of %s failed: %s", src.URL(), err)
+			status.State = v1alpha1.CACHE_FAILED
+			status.Message = err.Error()
+		} else {
+			status.State = v1alpha1.CACHE_CACHED
+			status.Size = info.Size
+			status.Digest = info.Digests[kipxe.DIGEST_SHA256]
+		}
+		this.updateCacheStatus(logger, obj, status)
+	}()
+}
+
+func (this *BootResources) updateCacheStatus(logger logger.LogContext, obj resources.Object, status *v1alpha1.CacheStatus) {
+	_, err := resources.ModifyStatus(obj, func(mod *resources.ModificationState) error {
+		m := mod.Data().(*v1alpha1.BootResource)
+		if !reflect.DeepEqual(m.Status.Cache, status) {
+			m.Status.Cache = status
+			mod.Modify(true)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Warnf("cannot update cache status of %s: %s", obj.ObjectName(), err)
+	}
+}
+
 func (this *BootResources) Delete(logger logger.LogContext, name resources.ObjectName) {
 	this.recheckUsers(logger, this.elements.Delete(name))
 }
@@ -136,14 +221,24 @@ func validateType(m *v1alpha1.BootResource) error {
 	if m.Spec.FieldName != "" && !field && len(found) > 0 {
 		return fmt.Errorf("field can only be used together with configMap, secret or metadata document")
 	}
+	if m.Spec.Digest != nil && m.Spec.URL == "" && !field {
+		return fmt.Errorf("digest can only be used together with url, configMap or secret")
+	}
 	return nil
 }
 
 func NewResource(obj resources.Object, cache kipxe.Cache) (*kipxe.BootResource, error) {
+	cms, _ := obj.Resources().Get(&v1.ConfigMap{})
+	secs, _ := obj.Resources().Get(&v1.Secret{})
+	return NewResourceForSpec(obj.Data().(*v1alpha1.BootResource), cache, ResourceGetter(cms), ResourceGetter(secs))
+}
+
+// NewResourceForSpec creates a boot resource for a resource object.
+// The object getters are used to access referenced config maps and secrets.
+func NewResourceForSpec(m *v1alpha1.BootResource, cache kipxe.Cache, configmaps, secrets ObjectGetter) (*kipxe.BootResource, error) {
 	var source kipxe.Source
 	var err error
 
-	m := obj.Data().(*v1alpha1.BootResource)
 	mime := strings.TrimSpace(m.Spec.MimeType)
 	if mime == "" {
 		return nil, fmt.Errorf("mime type empty")
@@ -151,13 +246,16 @@ func NewResource(obj resources.Object, cache kipxe.Cache) (*kipxe.BootResource,
 	if err = validateType(m); err != nil {
 		return nil, err
 	}
+	digests, err := Digests(m.Spec.Digest)
+	if err != nil {
+		return nil, err
+	}
 
 	if m.Spec.Text != "" {
-		_, err := template.New(m.Name).Parse(m.Spec.Text)
+		source, err = kipxe.NewTemplateSource("document", mime, m.Spec.Text)
 		if err != nil {
 			return nil, fmt.Errorf("text is no valid go template: %s", err)
 		}
-		source = kipxe.NewTextSource(mime, m.Spec.Text)
 	}
 
 	if m.Spec.Binary != "" {
@@ -173,6 +271,22 @@ func NewResource(obj resources.Object, cache kipxe.Cache) (*kipxe.BootResource,
 		if m.Spec.Volatile {
 			cache = nil
 		}
+		if digests != nil {
+			if cache == nil {
+				return nil, fmt.Errorf("digest verification requires a non-volatile cached URL")
+			}
+			if m.Spec.Redirect != nil && *m.Spec.Redirect {
+				return nil, fmt.Errorf("digest verification not possible for redirected URL")
+			}
+		}
+		if cache != nil && (m.Spec.CachePolicy != nil || digests != nil) {
+			policy := cache.Policy()
+			if m.Spec.CachePolicy != nil {
+				policy = CachePolicy(policy, m.Spec.CachePolicy)
+			}
+			policy.Digests = digests
+			cache = cache.WithPolicy(policy)
+		}
 		src, err = kipxe.NewMappedURLSource(mime, m.Spec.URL, cache)
 		if err != nil {
 			return nil, err
@@ -184,17 +298,21 @@ func NewResource(obj resources.Object, cache kipxe.Cache) (*kipxe.BootResource,
 	}
 
 	if m.Spec.ConfigMap != "" {
-		r, _ := obj.Resources().Get(&v1.ConfigMap{})
-		source, err = NewMappedObjectSource(NewConfigMapSource(r, resources.NewObjectName(m.Namespace, m.Spec.ConfigMap), m.Spec.FieldName, mime))
+		source, err = NewMappedObjectSource(NewConfigMapSource(configmaps, resources.NewObjectName(m.Namespace, m.Spec.ConfigMap), m.Spec.FieldName, mime).WithDigests(digests))
 	}
 	if m.Spec.Secret != "" {
-		r, _ := obj.Resources().Get(&v1.Secret{})
-		source, err = NewMappedObjectSource(NewSecretSource(r, resources.NewObjectName(m.Namespace, m.Spec.Secret), m.Spec.FieldName, mime))
+		source, err = NewMappedObjectSource(NewSecretSource(secrets, resources.NewObjectName(m.Namespace, m.Spec.Secret), m.Spec.FieldName, mime).WithDigests(digests))
 	}
 
 	if err != nil {
 		return nil, err
 	}
+	if m.Spec.Prefetch {
+		_, mapper := source.(kipxe.SourceMapper)
+		if m.Spec.URL == "" || mapper || m.Spec.Volatile {
+			return nil, fmt.Errorf("prefetch requires a static, non-volatile URL without redirection")
+		}
+	}
 	if source == nil {
 		source = kipxe.NewMetaDataSource(mime, m.Spec.FieldName)
 	}
@@ -212,21 +330,56 @@ func NewResource(obj resources.Object, cache kipxe.Cache) (*kipxe.BootResource,
 
 type fieldFetcher func(obj runtime.Object, name string) ([]byte, error)
 
+// ObjectGetter provides access to objects used as content source.
+type ObjectGetter func(name resources.ObjectName) (runtime.Object, error)
+
+// ResourceGetter provides an object getter for a cluster resource.
+func ResourceGetter(resc resources.Interface) ObjectGetter {
+	return func(name resources.ObjectName) (runtime.Object, error) {
+		if resc == nil {
+			return nil, fmt.Errorf("no resource access for %s", name)
+		}
+		obj, err := resc.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		return obj.Data(), nil
+	}
+}
+
 type objectSource struct {
 	kipxe.SourceSupport
-	resource resources.Interface
-	name     resources.ObjectName
-	field    string
-	fetch    fieldFetcher
+	getter  ObjectGetter
+	name    resources.ObjectName
+	field   string
+	fetch   fieldFetcher
+	digests kipxe.Digests
 }
 
 var _ kipxe.Source = &objectSource{}
 
-func (this *objectSource) get() (resources.Object, error) {
-	return this.resource.Get(this.name)
+// WithDigests configures the expected digests for the content.
+func (this *objectSource) WithDigests(digests kipxe.Digests) *objectSource {
+	this.digests = digests
+	return this
+}
+
+func (this *objectSource) get() (runtime.Object, error) {
+	return this.getter(this.name)
 }
 
 func (this *objectSource) Bytes() ([]byte, error) {
+	data, err := this.content()
+	if err != nil {
+		return nil, err
+	}
+	if err := this.digests.Verify(data); err != nil {
+		return nil, fmt.Errorf("content of object %s: %s", this.name, err)
+	}
+	return data, nil
+}
+
+func (this *objectSource) content() ([]byte, error) {
 	obj, err := this.get()
 	if err != nil {
 		return nil, err
@@ -235,9 +388,9 @@ func (this *objectSource) Bytes() ([]byte, error) {
 		if this.MimeType() == kipxe.MIME_YAML {
 			return yaml.Marshal(obj)
 		}
-		return json.Marshal(obj.Data())
+		return json.Marshal(obj)
 	}
-	return this.fetch(obj.Data(), this.field)
+	return this.fetch(obj, this.field)
 }
 
 func (this *objectSource) Serve(w http.ResponseWriter, r *http.Request) {
@@ -250,6 +403,10 @@ func (this *objectSource) Serve(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if len(this.digests) > 0 {
+		this.digests.SetHeader(w)
+		w.Header().Set(kipxe.CONTENT_ETAG, this.digests.ETag())
+	}
 	this.Iserve(data, w, r)
 }
 
@@ -313,10 +470,10 @@ func (this *mappedObjectSource) Map(values simple.Values) (kipxe.Source, error)
 
 ////////////////////////////////////////////////////////////////////////////////
 
-func NewConfigMapSource(resc resources.Interface, name resources.ObjectName, field string, mimeType string) *objectSource {
+func NewConfigMapSource(getter ObjectGetter, name resources.ObjectName, field string, mimeType string) *objectSource {
 	return &objectSource{
 		SourceSupport: kipxe.NewSourceSupport(mimeType),
-		resource:      resc,
+		getter:        getter,
 		name:          name,
 		field:         field,
 		fetch: func(obj runtime.Object, field string) ([]byte, error) {
@@ -334,10 +491,10 @@ func NewConfigMapSource(resc resources.Interface, name resources.ObjectName, fie
 	}
 }
 
-func NewSecretSource(resc resources.Interface, name resources.ObjectName, field string, mimeType string) *objectSource {
+func NewSecretSource(getter ObjectGetter, name resources.ObjectName, field string, mimeType string) *objectSource {
 	return &objectSource{
 		SourceSupport: kipxe.NewSourceSupport(mimeType),
-		resource:      resc,
+		getter:        getter,
 		name:          name,
 		field:         field,
 		fetch: func(obj runtime.Object, field string) ([]byte, error) {
@@ -350,3 +507,29 @@ func NewSecretSource(resc resources.Interface, name resources.ObjectName, field
 		},
 	}
 }
+
+// Digests provides the expected content digests of a resource.
+func Digests(spec *v1alpha1.Digest) (kipxe.Digests, error) {
+	if spec == nil {
+		return nil, nil
+	}
+	return kipxe.NewDigests(map[string]string{
+		kipxe.DIGEST_SHA256: spec.SHA256,
+		kipxe.DIGEST_SHA512: spec.SHA512,
+	})
+}
+
+// CachePolicy overwrites a cache policy by the settings
+// of a resource cache policy.
+func CachePolicy(policy kipxe.CachePolicy, spec *v1alpha1.CachePolicy) kipxe.CachePolicy {
+	if spec.MaxAge != nil {
+		policy.MaxAge = spec.MaxAge.Duration
+	}
+	if spec.StaleWhileRevalidate != nil {
+		policy.StaleWhileRevalidate = spec.StaleWhileRevalidate.Duration
+	}
+	if spec.StaleIfError != nil {
+		policy.StaleIfError = spec.StaleIfError.Duration
+	}
+	return policy
+}
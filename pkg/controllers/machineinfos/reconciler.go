@@ -0,0 +1,70 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package machineinfos
+
+import (
+	"github.com/gardener/controller-manager-library/pkg/controllermanager/controller"
+	"github.com/gardener/controller-manager-library/pkg/controllermanager/controller/reconcile"
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/gardener/controller-manager-library/pkg/resources"
+	mach "github.com/onmetal/k8s-machines/pkg/controllers"
+	"github.com/onmetal/k8s-machines/pkg/machines"
+
+	"github.com/mandelsoft/kipxe/pkg/indexmapper"
+)
+
+type reconciler struct {
+	reconcile.DefaultReconciler
+
+	controller controller.Interface
+	config     *Config
+
+	machines *indexmapper.Indexer
+}
+
+var _ reconcile.Interface = &reconciler{}
+
+func (this *reconciler) Setup() error {
+	err := this.machines.Setup(this.controller, this.controller.GetMainCluster())
+	if err == nil {
+		mach.PropagateMachineInfos(this.machines)
+	}
+	return err
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+func (this *reconciler) Reconcile(logger logger.LogContext, obj resources.Object) reconcile.Status {
+	if this.config.LocalNamespaceOnly && obj.GetNamespace() != this.controller.GetEnvironment().Namespace() {
+		return reconcile.Succeeded(logger)
+	}
+	logger.Infof("reconcile")
+
+	m, err, err2 := machines.ValidateMachine(logger, obj)
+	if err == nil {
+		this.machines.Set(m)
+	}
+	return reconcile.DelayOnError(logger, err2)
+}
+
+func (this *reconciler) Deleted(logger logger.LogContext, key resources.ClusterObjectKey) reconcile.Status {
+	logger.Infof("deleted")
+	this.machines.Delete(key.ObjectName())
+	return reconcile.Succeeded(logger)
+}
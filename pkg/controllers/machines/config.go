@@ -0,0 +1,44 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package machines
+
+import (
+	"fmt"
+
+	"github.com/gardener/controller-manager-library/pkg/config"
+)
+
+type Config struct {
+	LocalNamespaceOnly bool
+	DiscoveryNamespace string
+	DiscoveryRate      int
+}
+
+func (this *Config) AddOptionsToSet(set config.OptionSet) {
+	set.AddBoolOption(&this.LocalNamespaceOnly, "local-namespace-only", "", false, "server only resources in local namespace")
+	set.AddStringOption(&this.DiscoveryNamespace, "discovery-namespace", "", "", "namespace to register machine resources for requests of unknown machines (disabled if not set)")
+	set.AddIntOption(&this.DiscoveryRate, "discovery-rate", "", 10, "maximum number of discovered machines registered per minute")
+}
+
+func (this *Config) Prepare() error {
+	if this.DiscoveryNamespace != "" && this.DiscoveryRate <= 0 {
+		return fmt.Errorf("discovery rate must be positive")
+	}
+	return nil
+}
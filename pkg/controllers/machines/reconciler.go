@@ -0,0 +1,119 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package machines
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gardener/controller-manager-library/pkg/controllermanager/controller"
+	"github.com/gardener/controller-manager-library/pkg/controllermanager/controller/reconcile"
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/gardener/controller-manager-library/pkg/resources"
+
+	api "github.com/mandelsoft/kipxe/pkg/apis/ipxe/v1alpha1"
+	"github.com/mandelsoft/kipxe/pkg/controllers"
+	"github.com/mandelsoft/kipxe/pkg/kipxe"
+	"github.com/mandelsoft/kipxe/pkg/machines"
+)
+
+type reconciler struct {
+	reconcile.DefaultReconciler
+
+	controller controller.Interface
+	config     *Config
+	index      *machines.Index
+}
+
+var _ reconcile.Interface = &reconciler{}
+
+func (this *reconciler) Setup() {
+	registry := controllers.GetSharedRegistry(this.controller)
+	registry.Register(machines.NewMapper(this.index, 100))
+	if this.config.DiscoveryNamespace != "" {
+		resc, err := this.controller.GetMainCluster().Resources().Get(&api.Machine{})
+		if err != nil {
+			panic(err)
+		}
+		this.controller.Infof("registering discovered machines in namespace %s (%d per minute)", this.config.DiscoveryNamespace, this.config.DiscoveryRate)
+		registry.Register(machines.NewDiscovery(this.index, resc, this.config.DiscoveryNamespace, this.config.DiscoveryRate))
+	}
+}
+
+func (this *reconciler) Reconcile(logger logger.LogContext, obj resources.Object) reconcile.Status {
+	if this.config.LocalNamespaceOnly && obj.GetNamespace() != this.controller.GetEnvironment().Namespace() {
+		return reconcile.Succeeded(logger)
+	}
+	logger.Infof("reconcile")
+	m, duplicates, err := machines.NewMachine(obj.Data().(*api.Machine))
+	if err != nil {
+		logger.Errorf("invalid machine: %s", err)
+		this.enqueue(this.index.Delete(obj.ObjectName()))
+		return this.updateStatus(logger, obj, api.STATE_INVALID, err.Error())
+	}
+	this.enqueue(this.index.Set(m))
+
+	state := api.STATE_READY
+	msg := "machine ok"
+	if m.Discovered {
+		state = api.STATE_DISCOVERED
+		msg = "discovered machine waiting for approval"
+	}
+	conflicts := this.index.Conflicts(m.Name)
+	if len(conflicts) > 0 {
+		state = api.STATE_CONFLICT
+		msg = fmt.Sprintf("conflicts: %s", strings.Join(conflicts, ", "))
+	}
+	if len(duplicates) > 0 {
+		if state == api.STATE_READY {
+			msg = ""
+		} else {
+			msg += "; "
+		}
+		msg += fmt.Sprintf("duplicates: %s", strings.Join(duplicates, ", "))
+	}
+	return this.updateStatus(logger, obj, state, msg)
+}
+
+func (this *reconciler) Deleted(logger logger.LogContext, key resources.ClusterObjectKey) reconcile.Status {
+	logger.Infof("deleted")
+	this.enqueue(this.index.Delete(key.ObjectName()))
+	return reconcile.Succeeded(logger)
+}
+
+func (this *reconciler) updateStatus(logger logger.LogContext, obj resources.Object, state, msg string) reconcile.Status {
+	_, err := resources.ModifyStatus(obj, func(mod *resources.ModificationState) error {
+		m := mod.Data().(*api.Machine)
+		mod.AssureStringValue(&m.Status.State, state)
+		mod.AssureStringValue(&m.Status.Message, msg)
+		return nil
+	})
+	return reconcile.DelayOnError(logger, err)
+}
+
+// enqueue triggers the reconciliation of machines whose conflict
+// state might have been changed.
+func (this *reconciler) enqueue(set kipxe.NameSet) {
+	cluster := this.controller.GetMainCluster().GetId()
+	for _, u := range set {
+		if o, ok := u.(resources.ObjectName); ok {
+			this.controller.EnqueueKey(resources.NewClusterKey(cluster, api.MACHINE, o.Namespace(), o.Name()))
+		}
+	}
+}
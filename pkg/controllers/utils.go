@@ -20,15 +20,32 @@ package controllers
 
 import (
 	"github.com/gardener/controller-manager-library/pkg/controllermanager/controller"
+	"github.com/gardener/controller-manager-library/pkg/controllermanager/extension"
 	"github.com/gardener/controller-manager-library/pkg/ctxutil"
 
+	"github.com/mandelsoft/kipxe/pkg/indexmapper"
 	"github.com/mandelsoft/kipxe/pkg/kipxe"
 )
 
 var registryKey = ctxutil.SimpleKey("registry")
+var machineIndexKey = ctxutil.SimpleKey("machineindex")
 
 func GetSharedRegistry(controller controller.Interface) *kipxe.Registry {
 	return controller.GetEnvironment().GetOrCreateSharedValue(registryKey, func() interface{} {
 		return kipxe.NewRegistry()
 	}).(*kipxe.Registry)
 }
+
+func GetOrCreateMachineIndex(env extension.Environment) *indexmapper.Indexer {
+	return env.ControllerManager().GetOrCreateSharedValue(machineIndexKey, func() interface{} {
+		return indexmapper.NewIndexer()
+	}).(*indexmapper.Indexer)
+}
+
+func GetMachineIndex(env extension.Environment) *indexmapper.Indexer {
+	i := env.ControllerManager().GetSharedValue(machineIndexKey)
+	if i != nil {
+		return i.(*indexmapper.Indexer)
+	}
+	return nil
+}
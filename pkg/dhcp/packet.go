@@ -0,0 +1,247 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package dhcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	OP_BOOTREQUEST = 1
+	OP_BOOTREPLY   = 2
+)
+
+const (
+	MSG_DISCOVER = 1
+	MSG_OFFER    = 2
+	MSG_REQUEST  = 3
+	MSG_ACK      = 5
+	MSG_INFORM   = 8
+)
+
+const (
+	OPT_PAD              = 0
+	OPT_VENDOR_SPECIFIC  = 43
+	OPT_MESSAGE_TYPE     = 53
+	OPT_SERVER_ID        = 54
+	OPT_VENDOR_CLASS     = 60
+	OPT_TFTP_SERVER_NAME = 66
+	OPT_BOOTFILE_NAME    = 67
+	OPT_USER_CLASS       = 77
+	OPT_CLIENT_ARCH      = 93
+	OPT_CLIENT_NDI       = 94
+	OPT_CLIENT_GUID      = 97
+	OPT_END              = 255
+)
+
+const VENDOR_PXE = "PXEClient"
+const VENDOR_HTTP = "HTTPClient"
+
+// PXE vendor sub options (option 43)
+const PXE_DISCOVERY_CONTROL = 6
+
+var magic = []byte{99, 130, 83, 99}
+
+const headerLen = 236
+
+// Packet is a DHCP (BOOTP) packet (RFC 2131).
+type Packet struct {
+	Op      byte
+	HType   byte
+	HLen    byte
+	Hops    byte
+	XId     uint32
+	Secs    uint16
+	Flags   uint16
+	CIAddr  net.IP
+	YIAddr  net.IP
+	SIAddr  net.IP
+	GIAddr  net.IP
+	CHAddr  net.HardwareAddr
+	SName   string
+	File    string
+	Options Options
+}
+
+type Options map[byte][]byte
+
+func (this Options) String(code byte) string {
+	return string(bytes.TrimRight(this[code], "\x00"))
+}
+
+func Parse(data []byte) (*Packet, error) {
+	if len(data) < headerLen+len(magic) {
+		return nil, fmt.Errorf("packet too short")
+	}
+	if !bytes.Equal(data[headerLen:headerLen+4], magic) {
+		return nil, fmt.Errorf("no dhcp magic cookie")
+	}
+	hlen := data[2]
+	if hlen > 16 {
+		return nil, fmt.Errorf("invalid hardware address length %d", hlen)
+	}
+	p := &Packet{
+		Op:      data[0],
+		HType:   data[1],
+		HLen:    hlen,
+		Hops:    data[3],
+		XId:     binary.BigEndian.Uint32(data[4:]),
+		Secs:    binary.BigEndian.Uint16(data[8:]),
+		Flags:   binary.BigEndian.Uint16(data[10:]),
+		CIAddr:  net.IP(append([]byte{}, data[12:16]...)),
+		YIAddr:  net.IP(append([]byte{}, data[16:20]...)),
+		SIAddr:  net.IP(append([]byte{}, data[20:24]...)),
+		GIAddr:  net.IP(append([]byte{}, data[24:28]...)),
+		CHAddr:  net.HardwareAddr(append([]byte{}, data[28:28+hlen]...)),
+		SName:   string(bytes.TrimRight(data[44:108], "\x00")),
+		File:    string(bytes.TrimRight(data[108:236], "\x00")),
+		Options: Options{},
+	}
+	opts := data[headerLen+4:]
+	for i := 0; i < len(opts); {
+		code := opts[i]
+		i++
+		if code == OPT_PAD {
+			continue
+		}
+		if code == OPT_END {
+			break
+		}
+		if i >= len(opts) {
+			return nil, fmt.Errorf("truncated option %d", code)
+		}
+		l := int(opts[i])
+		i++
+		if i+l > len(opts) {
+			return nil, fmt.Errorf("truncated option %d", code)
+		}
+		// concatenate split options (RFC 3396)
+		p.Options[code] = append(p.Options[code], opts[i:i+l]...)
+		i += l
+	}
+	return p, nil
+}
+
+func (this *Packet) MessageType() byte {
+	if v := this.Options[OPT_MESSAGE_TYPE]; len(v) == 1 {
+		return v[0]
+	}
+	return 0
+}
+
+// VendorClass returns the PXE relevant part of the vendor class
+// identifier (PXEClient or HTTPClient) or an empty string.
+func (this *Packet) VendorClass() string {
+	v := this.Options.String(OPT_VENDOR_CLASS)
+	switch {
+	case strings.HasPrefix(v, VENDOR_PXE):
+		return VENDOR_PXE
+	case strings.HasPrefix(v, VENDOR_HTTP):
+		return VENDOR_HTTP
+	}
+	return ""
+}
+
+// Arch returns the client system architecture type (option 93)
+// or -1 if not present.
+func (this *Packet) Arch() int {
+	if v := this.Options[OPT_CLIENT_ARCH]; len(v) >= 2 {
+		return int(binary.BigEndian.Uint16(v))
+	}
+	return -1
+}
+
+// UUID returns the client machine identifier (option 97)
+// formatted as UUID string. The first three fields are
+// encoded little endian like for SMBIOS.
+func (this *Packet) UUID() string {
+	v := this.Options[OPT_CLIENT_GUID]
+	if len(v) != 17 || v[0] != 0 {
+		return ""
+	}
+	g := v[1:]
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(g[0:4]),
+		binary.LittleEndian.Uint16(g[4:6]),
+		binary.LittleEndian.Uint16(g[6:8]),
+		g[8:10], g[10:16])
+}
+
+func ip4(ip net.IP) []byte {
+	if ip == nil {
+		return []byte{0, 0, 0, 0}
+	}
+	if v := ip.To4(); v != nil {
+		return v
+	}
+	return []byte{0, 0, 0, 0}
+}
+
+func (this *Packet) Marshal() []byte {
+	buf := make([]byte, headerLen, 512)
+	buf[0] = this.Op
+	buf[1] = this.HType
+	buf[2] = this.HLen
+	buf[3] = this.Hops
+	binary.BigEndian.PutUint32(buf[4:], this.XId)
+	binary.BigEndian.PutUint16(buf[8:], this.Secs)
+	binary.BigEndian.PutUint16(buf[10:], this.Flags)
+	copy(buf[12:16], ip4(this.CIAddr))
+	copy(buf[16:20], ip4(this.YIAddr))
+	copy(buf[20:24], ip4(this.SIAddr))
+	copy(buf[24:28], ip4(this.GIAddr))
+	copy(buf[28:44], this.CHAddr)
+	copy(buf[44:107], this.SName)
+	copy(buf[108:235], this.File)
+	buf = append(buf, magic...)
+
+	// message type first
+	if v, ok := this.Options[OPT_MESSAGE_TYPE]; ok {
+		buf = appendOption(buf, OPT_MESSAGE_TYPE, v)
+	}
+	for code := 1; code < OPT_END; code++ {
+		if v, ok := this.Options[byte(code)]; ok && code != OPT_MESSAGE_TYPE {
+			buf = appendOption(buf, byte(code), v)
+		}
+	}
+	buf = append(buf, OPT_END)
+	for len(buf) < 300 {
+		buf = append(buf, OPT_PAD)
+	}
+	return buf
+}
+
+func appendOption(buf []byte, code byte, value []byte) []byte {
+	for {
+		l := len(value)
+		if l > 255 {
+			l = 255
+		}
+		buf = append(buf, code, byte(l))
+		buf = append(buf, value[:l]...)
+		value = value[l:]
+		if len(value) == 0 {
+			return buf
+		}
+	}
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package dhcp
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/gardener/controller-manager-library/pkg/ctxutil"
+	"github.com/gardener/controller-manager-library/pkg/logger"
+)
+
+const PORT_DHCP = 67
+const PORT_CLIENT = 68
+const PORT_PROXY = 4011
+
+// BootInfo describes the boot information passed to a PXE client.
+// If no next server is given, the address of the proxy server is used.
+// The next server must be an IPv4 address.
+type BootInfo struct {
+	Filename   string
+	NextServer net.IP
+}
+
+// Handler determines the boot information for a PXE request.
+// If nil is returned, the request is not answered.
+type Handler interface {
+	BootInfo(logger logger.LogContext, req *Packet, remote *net.UDPAddr) (*BootInfo, error)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ProxyServer is a ProxyDHCP responder according to the PXE specification.
+// It only answers requests of PXE (or UEFI HTTP boot) clients and provides
+// the boot information, only. No IP addresses are assigned, this is left
+// to the regular DHCP server.
+// DHCPDISCOVER requests on the DHCP port are answered with a DHCPOFFER,
+// DHCPREQUEST requests on the proxy port are answered with a DHCPACK.
+type ProxyServer struct {
+	logger.LogContext
+	ctx      context.Context
+	name     string
+	serverIP net.IP
+	handler  Handler
+}
+
+func NewProxyServer(ctx context.Context, logger logger.LogContext, name string, serverIP net.IP, handler Handler) *ProxyServer {
+	return &ProxyServer{
+		LogContext: logger,
+		ctx:        ctx,
+		name:       name,
+		serverIP:   serverIP.To4(),
+		handler:    handler,
+	}
+}
+
+// Start starts the responder on the DHCP port and the ProxyDHCP port.
+func (this *ProxyServer) Start(bindAddress string) error {
+	if this.serverIP == nil {
+		return fmt.Errorf("server address must be an IPv4 address")
+	}
+	for _, port := range []int{PORT_DHCP, PORT_PROXY} {
+		if err := this.listen(bindAddress, port); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *ProxyServer) listen(bindAddress string, port int) error {
+	listenAddress := fmt.Sprintf("%s:%d", bindAddress, port)
+	addr, err := net.ResolveUDPAddr("udp4", listenAddress)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp4", addr)
+	if err != nil {
+		return err
+	}
+	this.Infof("starting %s as proxy dhcp server (serving on %s)", this.name, listenAddress)
+
+	ctxutil.WaitGroupAdd(this.ctx)
+	go func() {
+		<-this.ctx.Done()
+		conn.Close()
+	}()
+
+	go func() {
+		defer ctxutil.WaitGroupDone(this.ctx)
+		buf := make([]byte, 1500)
+		for {
+			n, remote, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				select {
+				case <-this.ctx.Done():
+					this.Infof("server %q (port %d) stopped", this.name, port)
+					return
+				default:
+				}
+				this.Errorf("dhcp read failed: %s", err)
+				continue
+			}
+			data := append([]byte{}, buf[:n]...)
+			go this.handle(conn, port, data, remote)
+		}
+	}()
+	return nil
+}
+
+func (this *ProxyServer) handle(conn *net.UDPConn, port int, data []byte, remote *net.UDPAddr) {
+	req, err := Parse(data)
+	if err != nil || req.Op != OP_BOOTREQUEST {
+		return
+	}
+	vendor := req.VendorClass()
+	if vendor == "" {
+		return
+	}
+
+	var reply byte
+	switch req.MessageType() {
+	case MSG_DISCOVER:
+		if port != PORT_DHCP {
+			return
+		}
+		reply = MSG_OFFER
+	case MSG_REQUEST, MSG_INFORM:
+		if port != PORT_PROXY {
+			return
+		}
+		reply = MSG_ACK
+	default:
+		return
+	}
+
+	this.Infof("%s request (type %d) from %s [%s]", vendor, req.MessageType(), req.CHAddr, remote)
+	info, err := this.handler.BootInfo(this.LogContext, req, remote)
+	if err != nil {
+		this.Warnf("no boot info for %s: %s", req.CHAddr, err)
+		return
+	}
+	if info == nil || info.Filename == "" {
+		this.Infof("no boot info for %s", req.CHAddr)
+		return
+	}
+	next := this.serverIP
+	if info.NextServer != nil {
+		next = info.NextServer.To4()
+		if next == nil {
+			this.Warnf("no IPv4 next server for %s: %s", req.CHAddr, info.NextServer)
+			return
+		}
+	}
+
+	resp := &Packet{
+		Op:      OP_BOOTREPLY,
+		HType:   req.HType,
+		HLen:    req.HLen,
+		XId:     req.XId,
+		Flags:   req.Flags,
+		CIAddr:  req.CIAddr,
+		GIAddr:  req.GIAddr,
+		SIAddr:  next,
+		CHAddr:  req.CHAddr,
+		File:    info.Filename,
+		Options: Options{},
+	}
+	resp.Options[OPT_MESSAGE_TYPE] = []byte{reply}
+	resp.Options[OPT_SERVER_ID] = []byte(this.serverIP)
+	resp.Options[OPT_VENDOR_CLASS] = []byte(vendor)
+	resp.Options[OPT_BOOTFILE_NAME] = []byte(info.Filename)
+	if guid := req.Options[OPT_CLIENT_GUID]; guid != nil {
+		resp.Options[OPT_CLIENT_GUID] = guid
+	}
+	if vendor == VENDOR_PXE {
+		// disable boot server discovery and use the boot file provided here
+		resp.Options[OPT_VENDOR_SPECIFIC] = []byte{PXE_DISCOVERY_CONTROL, 1, 8, OPT_END}
+	}
+
+	dst := remote
+	if port == PORT_DHCP {
+		switch {
+		case !ip4zero(req.GIAddr):
+			dst = &net.UDPAddr{IP: req.GIAddr, Port: PORT_DHCP}
+		case !ip4zero(req.CIAddr):
+			dst = &net.UDPAddr{IP: req.CIAddr, Port: PORT_CLIENT}
+		default:
+			dst = &net.UDPAddr{IP: net.IPv4bcast, Port: PORT_CLIENT}
+		}
+	}
+	this.Infof("boot file for %s: %s (next server %s)", req.CHAddr, info.Filename, next)
+	if _, err := conn.WriteToUDP(resp.Marshal(), dst); err != nil {
+		this.Errorf("cannot send dhcp reply to %s: %s", dst, err)
+	}
+}
+
+func ip4zero(ip net.IP) bool {
+	return ip == nil || ip.To4() == nil || ip.To4().Equal(net.IPv4zero)
+}
@@ -0,0 +1,252 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package indexmapper
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gardener/controller-manager-library/pkg/controllermanager/cluster"
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/gardener/controller-manager-library/pkg/resources"
+	api "github.com/onmetal/k8s-machines/pkg/apis/machines/v1alpha1"
+	"github.com/onmetal/k8s-machines/pkg/machines"
+	"k8s.io/apimachinery/pkg/labels"
+
+	kmachines "github.com/mandelsoft/kipxe/pkg/machines"
+)
+
+// KeyIndex is implemented by machine indices supporting lookups by
+// lookup keys other than UUID and MAC address.
+type KeyIndex interface {
+	GetByKey(key, value string) *machines.Machine
+}
+
+// Indexer is a machine indexer for MachineInfo resources normalizing
+// UUIDs and MAC addresses. Additionally it supports lookups by
+// arbitrary fields of the machine values. Field indices are created
+// on first use.
+type Indexer struct {
+	initlock    sync.RWMutex
+	lock        sync.RWMutex
+	initialized int32
+	elements    map[resources.ObjectName]*machines.Machine
+	byMACs      map[string]*machines.Machine
+	byUUIDs     map[string]*machines.Machine
+	fields      map[string]map[string]*machines.Machine
+}
+
+var _ machines.MachineIndexer = &Indexer{}
+var _ KeyIndex = &Indexer{}
+
+func NewIndexer() *Indexer {
+	m := &Indexer{
+		elements: map[resources.ObjectName]*machines.Machine{},
+		byMACs:   map[string]*machines.Machine{},
+		byUUIDs:  map[string]*machines.Machine{},
+		fields:   map[string]map[string]*machines.Machine{},
+	}
+	m.initlock.Lock()
+	return m
+}
+
+func (this *Indexer) Wait() {
+	this.initlock.RLock()
+	this.initlock.RUnlock()
+}
+
+func (this *Indexer) GetByMAC(mac string) *machines.Machine {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	return this.byMACs[Normalize(KEY_MAC, mac)]
+}
+
+func (this *Indexer) GetByUUID(uuid string) *machines.Machine {
+	uuid = Normalize(KEY_UUID, uuid)
+
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	if m := this.byUUIDs[uuid]; m != nil {
+		return m
+	}
+	return this.byUUIDs[kmachines.SwapUUID(uuid)]
+}
+
+func (this *Indexer) GetByName(name resources.ObjectName) *machines.Machine {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	return this.elements[name]
+}
+
+func (this *Indexer) GetByKey(key, value string) *machines.Machine {
+	switch key {
+	case KEY_UUID:
+		return this.GetByUUID(value)
+	case KEY_MAC:
+		return this.GetByMAC(value)
+	}
+	value = Normalize(key, value)
+
+	this.lock.RLock()
+	index := this.fields[key]
+	if index != nil {
+		defer this.lock.RUnlock()
+		return index[value]
+	}
+	this.lock.RUnlock()
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	index = this.fields[key]
+	if index == nil {
+		index = map[string]*machines.Machine{}
+		for _, m := range this.elements {
+			for _, v := range fieldValues(m, key) {
+				index[v] = m
+			}
+		}
+		this.fields[key] = index
+	}
+	return index[value]
+}
+
+func (this *Indexer) Setup(logger logger.LogContext, cluster cluster.Interface) error {
+	if atomic.LoadInt32(&this.initialized) != 0 {
+		logger.Infof("machine cache already initialized")
+		return nil
+	}
+	if cluster == nil {
+		logger.Infof("waiting for machine cache")
+		this.Wait()
+		return nil
+	}
+
+	resc, err := cluster.Resources().Get(api.MACHINEINFO)
+	if err != nil {
+		return err
+	}
+	logger.Infof("setup machines")
+	list, _ := resc.ListCached(labels.Everything())
+
+	for _, l := range list {
+		elem, err, _ := machines.ValidateMachine(logger, l)
+		if elem != nil {
+			this.Set(elem)
+			logger.Infof("found machine %s", elem.Name)
+		}
+		if err != nil {
+			logger.Infof("errorneous machine %s: %s", l.GetName(), err)
+		}
+	}
+	logger.Infof("machine cache setup done")
+	atomic.StoreInt32(&this.initialized, 1)
+	this.initlock.Unlock()
+	return nil
+}
+
+func (this *Indexer) Set(m *machines.Machine) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	old := this.elements[m.Name]
+	if old != nil {
+		this.cleanup(old)
+	}
+	this.set(m)
+	return nil
+}
+
+func (this *Indexer) Delete(name resources.ObjectName) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	old := this.elements[name]
+	if old != nil {
+		this.cleanup(old)
+	}
+}
+
+func (this *Indexer) cleanup(m *machines.Machine) {
+	for _, n := range m.NICs {
+		unset(this.byMACs, Normalize(KEY_MAC, n.MAC), m)
+	}
+	unset(this.byUUIDs, Normalize(KEY_UUID, m.UUID), m)
+	for key, index := range this.fields {
+		for _, v := range fieldValues(m, key) {
+			unset(index, v, m)
+		}
+	}
+	delete(this.elements, m.Name)
+}
+
+func (this *Indexer) set(m *machines.Machine) {
+	for _, n := range m.NICs {
+		if mac := Normalize(KEY_MAC, n.MAC); mac != "" {
+			this.byMACs[mac] = m
+		}
+	}
+	if uuid := Normalize(KEY_UUID, m.UUID); uuid != "" {
+		this.byUUIDs[uuid] = m
+	}
+	for key, index := range this.fields {
+		for _, v := range fieldValues(m, key) {
+			index[v] = m
+		}
+	}
+	this.elements[m.Name] = m
+}
+
+// unset removes an index entry only if it still refers to the given
+// machine, another machine might have claimed the key in the meantime.
+func unset(index map[string]*machines.Machine, key string, m *machines.Machine) {
+	if index[key] == m {
+		delete(index, key)
+	}
+}
+
+// fieldValues provides the normalized values of a field of the machine
+// values. Lists are indexed by all their elements.
+func fieldValues(m *machines.Machine, key string) []string {
+	if m.Values.Values == nil {
+		return nil
+	}
+	var result []string
+	add := func(v interface{}) {
+		switch v.(type) {
+		case nil, map[string]interface{}:
+		default:
+			if n := Normalize(key, fmt.Sprintf("%v", v)); n != "" {
+				result = append(result, n)
+			}
+		}
+	}
+	switch v := m.Values.Values[key].(type) {
+	case []interface{}:
+		for _, e := range v {
+			add(e)
+		}
+	default:
+		add(v)
+	}
+	return result
+}
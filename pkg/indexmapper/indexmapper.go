@@ -19,7 +19,9 @@
 package indexmapper
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gardener/controller-manager-library/pkg/convert"
 	"github.com/gardener/controller-manager-library/pkg/logger"
@@ -27,15 +29,23 @@ import (
 	"github.com/onmetal/k8s-machines/pkg/machines"
 
 	"github.com/mandelsoft/kipxe/pkg/kipxe"
+	kmachines "github.com/mandelsoft/kipxe/pkg/machines"
 )
 
 type IndexMapper struct {
 	index  machines.MachineIndex
 	weight int
+	keys   []LookupKey
 }
 
-func NewIndexMapper(index machines.MachineIndex, weight int) kipxe.MetaDataMapper {
-	return &IndexMapper{index, weight}
+// NewIndexMapper creates a mapper looking up machines by the given
+// keys in precedence order. Without keys the DefaultLookupKeys are used.
+// Keys other than uuid and mac require an index implementing KeyIndex.
+func NewIndexMapper(index machines.MachineIndex, weight int, keys ...LookupKey) kipxe.MetaDataMapper {
+	if len(keys) == 0 {
+		keys, _ = ParseLookupKeys(DefaultLookupKeys)
+	}
+	return &IndexMapper{index, weight, keys}
 }
 
 func (this *IndexMapper) Weight() int {
@@ -43,22 +53,36 @@ func (this *IndexMapper) Weight() int {
 }
 
 func (this *IndexMapper) String() string {
-	return "machine index mapper"
+	return fmt.Sprintf("machine index mapper %v", this.keys)
 }
 
 func (this *IndexMapper) Lookup(values kipxe.MetaData) *machines.Machine {
-	uuid := values["uuid"]
-	if uuid != nil {
-		m := this.index.GetByUUID(uuid.(string))
-		if m != nil {
-			return m
+	for _, k := range this.keys {
+		for _, v := range k.Values(values) {
+			if m := this.get(k.Name, v); m != nil {
+				return m
+			}
 		}
 	}
-	macs := values["__mac__"]
-	if macs != nil {
-		for _, v := range macs.([]interface{}) {
-			m := this.index.GetByMAC(v.(string))
-			if m != nil {
+	return nil
+}
+
+func (this *IndexMapper) get(key, value string) *machines.Machine {
+	if index, ok := this.index.(KeyIndex); ok {
+		return index.GetByKey(key, value)
+	}
+	// plain indices just match the given strings, therefore
+	// the common representations are tried.
+	switch key {
+	case KEY_UUID:
+		for _, v := range []string{value, strings.ToUpper(value), kmachines.SwapUUID(value), strings.ToUpper(kmachines.SwapUUID(value))} {
+			if m := this.index.GetByUUID(v); v != "" && m != nil {
+				return m
+			}
+		}
+	case KEY_MAC:
+		for _, v := range []string{value, strings.ToUpper(value)} {
+			if m := this.index.GetByMAC(v); m != nil {
 				return m
 			}
 		}
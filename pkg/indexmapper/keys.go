@@ -0,0 +1,135 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package indexmapper
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/mandelsoft/kipxe/pkg/kipxe"
+	kmachines "github.com/mandelsoft/kipxe/pkg/machines"
+)
+
+const KEY_UUID = "uuid"
+const KEY_MAC = "mac"
+const KEY_SERIAL = "serial"
+const KEY_ASSET = "asset"
+const KEY_HOSTNAME = "hostname"
+const KEY_IP = "ip"
+
+// DefaultLookupKeys are the keys used to identify a machine if nothing
+// else is configured.
+var DefaultLookupKeys = []string{KEY_UUID, KEY_MAC}
+
+// defaultFields maps well-known lookup keys to request metadata fields
+// differing from the key name.
+var defaultFields = map[string]string{
+	KEY_IP: "ORIGIN",
+}
+
+// LookupKey describes a machine index key (the UUID, a MAC address or
+// a field of the machine values) and the request metadata field
+// providing the value to look for.
+type LookupKey struct {
+	Name  string
+	Field string
+}
+
+func (this LookupKey) String() string {
+	if this.Field == this.Name {
+		return this.Name
+	}
+	return this.Name + "=" + this.Field
+}
+
+// Values provides the normalized values of the lookup key found in the
+// request metadata. Values passed several times are provided as
+// list by the request metadata under __<field>__.
+func (this LookupKey) Values(values kipxe.MetaData) []string {
+	var result []string
+	list, ok := values["__"+this.Field+"__"].([]interface{})
+	if !ok {
+		list = []interface{}{values[this.Field]}
+	}
+	for _, v := range list {
+		if s, ok := v.(string); ok {
+			if n := Normalize(this.Name, s); n != "" {
+				result = append(result, n)
+			}
+		}
+	}
+	return result
+}
+
+// ParseLookupKeys parses a list of lookup key specifications in
+// precedence order. A specification is either a key name or
+// <key>=<metadata field>. By default the metadata field is named like
+// the key, only the key ip uses the request origin.
+func ParseLookupKeys(specs []string) ([]LookupKey, error) {
+	var keys []LookupKey
+	found := map[string]bool{}
+	for _, spec := range specs {
+		for _, s := range strings.Split(spec, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			key := LookupKey{Name: s, Field: s}
+			if i := strings.Index(s, "="); i >= 0 {
+				key.Name = strings.TrimSpace(s[:i])
+				key.Field = strings.TrimSpace(s[i+1:])
+			} else if f, ok := defaultFields[s]; ok {
+				key.Field = f
+			}
+			if key.Name == "" || key.Field == "" {
+				return nil, fmt.Errorf("invalid lookup key %q", s)
+			}
+			if found[key.Name] {
+				return nil, fmt.Errorf("duplicate lookup key %q", key.Name)
+			}
+			found[key.Name] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Normalize provides the canonical representation of a key value
+// used for indexing and lookup.
+func Normalize(key, value string) string {
+	value = strings.TrimSpace(value)
+	switch key {
+	case KEY_UUID:
+		return kmachines.NormalizeUUID(value)
+	case KEY_MAC:
+		n, err := kmachines.NormalizeMAC(value)
+		if err != nil {
+			return strings.ToLower(value)
+		}
+		return n
+	case KEY_HOSTNAME:
+		return strings.TrimSuffix(strings.ToLower(value), ".")
+	case KEY_IP:
+		if ip := net.ParseIP(value); ip != nil {
+			return ip.String()
+		}
+	}
+	return value
+}
@@ -20,7 +20,7 @@ package kipxe
 
 import (
 	"bufio"
-	"bytes"
+	"compress/gzip"
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
@@ -30,8 +30,11 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -57,6 +60,93 @@ type Cache interface {
 	Bytes(url *url.URL) ([]byte, error)
 	Serve(url *url.URL, w http.ResponseWriter, r *http.Request)
 	Cleanup(logger logger.LogContext, ttl time.Duration)
+
+	// Policy provides the revalidation policy used by the cache.
+	Policy() CachePolicy
+	// WithPolicy provides a view on the cache using a dedicated
+	// revalidation policy.
+	WithPolicy(policy CachePolicy) Cache
+
+	// Prefetch assures the content of a URL to be cached. It waits
+	// for the completion of a required cache fill.
+	Prefetch(url *url.URL) (*CacheInfo, error)
+}
+
+// CacheInfo describes a cache entry.
+type CacheInfo struct {
+	Key         string    `json:"key,omitempty"`
+	URL         string    `json:"url"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"contentType,omitempty"`
+	Digests     Digests   `json:"digests,omitempty"`
+	Fetched     time.Time `json:"fetched,omitempty"`
+	LastAccess  time.Time `json:"lastAccess,omitempty"`
+}
+
+// CachePolicy describes when cached content is revalidated
+// with the origin. If MaxAge is zero, cached content is always
+// considered fresh. If Digests are given, the content
+// is verified when filling the cache, mismatching content
+// is neither cached nor served.
+type CachePolicy struct {
+	MaxAge               time.Duration
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+	Digests              Digests
+}
+
+// freshness states of a cache entry
+const (
+	entryMissing = iota
+	entryFresh
+	entryRevalidate // stale, but still served while revalidating
+	entryStale
+)
+
+func (this *CachePolicy) state(age time.Duration) int {
+	switch {
+	case this.MaxAge <= 0 || age <= this.MaxAge:
+		return entryFresh
+	case age <= this.MaxAge+this.StaleWhileRevalidate:
+		return entryRevalidate
+	default:
+		return entryStale
+	}
+}
+
+func (this *CachePolicy) usableOnError(age time.Duration) bool {
+	return age <= this.MaxAge+this.StaleIfError
+}
+
+type policyCache struct {
+	cache  *DirCache
+	policy CachePolicy
+}
+
+var _ Cache = &policyCache{}
+
+func (this *policyCache) Bytes(url *url.URL) ([]byte, error) {
+	return this.cache.bytes(url, &this.policy)
+}
+
+func (this *policyCache) Serve(url *url.URL, w http.ResponseWriter, r *http.Request) {
+	this.cache.serve(url, &this.policy, w, r)
+}
+
+func (this *policyCache) Cleanup(logger logger.LogContext, ttl time.Duration) {
+	this.cache.Cleanup(logger, ttl)
+}
+
+func (this *policyCache) Policy() CachePolicy {
+	return this.policy
+}
+
+func (this *policyCache) WithPolicy(policy CachePolicy) Cache {
+	return this.cache.WithPolicy(policy)
+}
+
+func (this *policyCache) Prefetch(url *url.URL) (*CacheInfo, error) {
+	return this.cache.prefetch(url, &this.policy)
 }
 
 type DirCache struct {
@@ -64,6 +154,19 @@ type DirCache struct {
 	logger.LogContext
 	path    string
 	actions map[string]*cacheAction
+
+	// size is the accumulated size of the cached content and
+	// pending the space reserved for ongoing cache fills.
+	size         int64
+	pending      int64
+	maxSize      int64
+	maxEntrySize int64
+
+	policy CachePolicy
+
+	// precompress is the minimum size of compressible content
+	// to keep a gzip compressed variant for (0 disables variants)
+	precompress int64
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -85,7 +188,7 @@ func (this *CacheAction) Bytes() ([]byte, error) {
 	if this.ref == nil {
 		return nil, fmt.Errorf("outdated")
 	}
-	return this.ref.Bytes()
+	return this.ref.Bytes(&this.ref.cache.policy)
 }
 
 func (this *CacheAction) Serve(w http.ResponseWriter, r *http.Request) {
@@ -94,7 +197,7 @@ func (this *CacheAction) Serve(w http.ResponseWriter, r *http.Request) {
 	if this.ref == nil {
 		return
 	}
-	this.ref.Serve(w, r)
+	this.ref.Serve(&this.ref.cache.policy, w, r)
 }
 
 func (this *CacheAction) Done() {
@@ -110,21 +213,18 @@ func (this *CacheAction) Done() {
 
 type cacheAction struct {
 	sync.RWMutex
-	cache    *DirCache
-	usecount int
-	url      *url.URL
-	key      string
-	base     string
+	cache        *DirCache
+	usecount     int // guarded by the lock of the cache
+	url          *url.URL
+	key          string
+	base         string
+	revalidating int32
+	validation   *revalidation
+	filling      *cacheFill
 }
 
 func (this *cacheAction) release() {
-	this.Lock()
-	defer this.Unlock()
-
-	this.usecount--
-	if this.usecount <= 0 {
-		this.cache.release(this.key)
-	}
+	this.cache.release(this)
 }
 
 func write(w io.Writer, data []byte) error {
@@ -141,168 +241,661 @@ func write(w io.Writer, data []byte) error {
 	return nil
 }
 
-func (this *cacheAction) fill(writer io.Writer) error {
-	err := this._fill(writer)
+// get requests the content from the origin. If cache metadata
+// is given, a conditional request is used.
+func (this *cacheAction) get(meta ResourceMeta) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, this.url.String(), nil)
 	if err != nil {
-		this.cache.remove(this.base)
+		return nil, err
 	}
-	return err
+	if meta != nil {
+		if etag := meta[CONTENT_ETAG]; etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if mod := meta[CONTENT_LAST_MODIFIED]; mod != "" {
+			req.Header.Set("If-Modified-Since", mod)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("URL get failed: %s", err)
+	}
+	return resp, nil
 }
 
-func (this *cacheAction) _fill(writer io.Writer) error {
-	this.cache.Infof("caching %s [%s]", this.url, this.base)
-	file, err := os.OpenFile(this.base, os.O_WRONLY|os.O_CREATE, 0660)
+// _startFill starts filling the cache entry in the background, if not
+// already in progress. If no response is given, the content is requested
+// from the origin. The write lock must be held.
+func (this *cacheAction) _startFill(resp *http.Response, policy *CachePolicy) (*cacheFill, error) {
+	if this.filling != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return this.filling, nil
+	}
+	tmp := cachetemp(this.base)
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
 	if err != nil {
-		return err
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, err
 	}
-	defer file.Close()
+	fill := newCacheFill(tmp, this.url, policy.Digests)
+	fill.replacing = fileExists(this.base)
+	this.filling = fill
+	this.cache.use(this)
+	go this.fill(fill, file, resp, *policy)
+	return fill, nil
+}
 
-	resp, err := http.Get(this.url.String())
+// fill executes a cache fill. If it fails or the content cannot be kept,
+// a previous cache entry is kept as long as it is usable on errors.
+func (this *cacheAction) fill(fill *cacheFill, file *os.File, resp *http.Response, policy CachePolicy) {
+	defer this.release()
+
+	err := this.store(fill, file, resp)
+	if err == nil && fill.keep {
+		err = file.Sync()
+	}
+	file.Close()
 	if err != nil {
-		return fmt.Errorf("URL get failed: %s", err)
+		cacheFillErrors.Inc()
+		this.cache.Errorf("caching %s failed: %s", this.url, err)
 	}
 
+	this.Lock()
+	defer this.Unlock()
+	if err == nil && fill.keep {
+		if cerr := this._commit(fill, &policy); cerr != nil {
+			cacheFillErrors.Inc()
+			this.cache.Errorf("caching %s failed: %s", this.url, cerr)
+			fill.keep = false
+		}
+	}
+	if err != nil || !fill.keep {
+		this.cache.discard(fill.path)
+		if this._usableOnError(&policy) {
+			this.cache.Warnf("keeping previous cache entry for %s", this.url)
+		} else {
+			this.cache.remove(this.base)
+		}
+	}
+	this.filling = nil
+	fill.finish(err)
+}
+
+// _usableOnError checks whether the existing cache entry may still be
+// served if the origin cannot be reached. It requires the lock.
+func (this *cacheAction) _usableOnError(policy *CachePolicy) bool {
+	if !fileExists(this.base) {
+		return false
+	}
+	meta := ResourceMeta{}
+	meta.Read(this.base)
+	return policy.usableOnError(meta.Age(this.base))
+}
+
+// fallback provides the previous cache entry replaced by a failed fill,
+// if it is still usable on errors.
+func (this *cacheAction) fallback(fill *cacheFill, policy *CachePolicy) *cacheContent {
+	if !fill.replacing || !fill.failed() {
+		return nil
+	}
+	this.RLock()
+	defer this.RUnlock()
+	if this.filling != nil || !this._usableOnError(policy) {
+		return nil
+	}
+	c, err := this._open()
+	if err != nil || c == nil {
+		return nil
+	}
+	this.cache.Warnf("cache fill for %s failed, serving previous content", this.url)
+	return c
+}
+
+// _commit atomically replaces the cache entry by the completely
+// written temporary file of a fill. The meta data is written
+// after the content, it records the content size to be able to detect
+// incomplete entries, and the policy used for the fill. It requires
+// the write lock.
+func (this *cacheAction) _commit(fill *cacheFill, policy *CachePolicy) error {
+	meta := ResourceMeta{}
+	for k, v := range fill.meta {
+		meta[k] = v
+	}
+	meta[CONTENT_SIZE] = strconv.FormatInt(fill.written, 10)
+	meta.SetDigests(fill.digests)
+	meta.SetPolicy(policy)
+
+	this.cache.remove(this.base)
+	if err := os.Rename(fill.path, this.base); err != nil {
+		return err
+	}
+	if err := meta.Write(this.base); err != nil {
+		return err
+	}
+	syncDir(this.cache.path)
+	return nil
+}
+
+// store writes the content of the origin to the cache file and
+// reports the progress to the readers of the fill.
+func (this *cacheAction) store(fill *cacheFill, file *os.File, resp *http.Response) error {
+	var err error
+	if resp == nil {
+		resp, err = this.get(nil)
+		if err != nil {
+			return err
+		}
+	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("URL get failed: %s", resp.Status)
+	}
+
+	this.cache.Infof("caching %s [%s]", this.url, this.base)
 	meta := ResourceMeta{}
 	meta[CONTENT_URL] = this.url.String()
-	if t := resp.Header.Get(CONTENT_TYPE); t != "" {
-		meta[CONTENT_TYPE] = t
+	for _, h := range []string{CONTENT_TYPE, CONTENT_ETAG, CONTENT_LAST_MODIFIED} {
+		if v := resp.Header.Get(h); v != "" {
+			meta[h] = v
+		}
+	}
+	meta.SetFetched(time.Now())
+
+	written := int64(0)
+	reserved := int64(0)
+	defer func() {
+		if reserved > written {
+			this.cache.unreserve(reserved - written)
+		}
+	}()
+
+	if resp.ContentLength > 0 {
+		if err := this.reserve(resp.ContentLength, &reserved); err != nil {
+			// pass through the content without caching it
+			this.cache.Warnf("skip caching %s: %s", this.url, err)
+			fill.start(meta, resp.ContentLength, true)
+			return nil
+		}
 	}
-	meta.Write(this.base)
+	fill.keep = true
+	fill.start(meta, resp.ContentLength, false)
 
+	digester := newDigester()
 	var tmp [8196]byte
-	var fail error
-	var wfail error
 	for {
 		n, err := resp.Body.Read(tmp[:])
 		if n > 0 {
-			if fail == nil {
-				fail = write(file, tmp[:n])
-				if writer == nil {
-					return fail
+			if fill.keep && written+int64(n) > reserved {
+				if err := this.reserve(written+int64(n)-reserved, &reserved); err != nil {
+					// the fill is completed for the actual readers,
+					// but the content is not kept in the cache
+					this.cache.Warnf("%s will not be kept in cache: %s", this.url, err)
+					fill.keep = false
 				}
 			}
-			if wfail == nil && writer != nil {
-				wfail = write(writer, tmp[:n])
+			if err := write(file, tmp[:n]); err != nil {
+				return err
 			}
+			digester.Write(tmp[:n])
+			r := reserved - written
+			if r > int64(n) {
+				r = int64(n)
+			}
+			if r < 0 {
+				r = 0
+			}
+			this.cache.written(int64(n), r)
+			written += int64(n)
+			cacheFillBytes.Add(float64(n))
+			fill.progress(int64(n))
 		}
 		if err != nil {
 			if err == io.EOF {
-				break
+				fill.digests = digester.Digests()
+				if err := fill.expected.Check(fill.digests); err != nil {
+					return fmt.Errorf("content of %s: %s", this.url, err)
+				}
+				return nil
 			}
 			return err
 		}
-		if n < 0 {
-			break
-		}
 	}
+}
+
+// reserve reserves cache space for the entry according to the
+// configured quota.
+func (this *cacheAction) reserve(n int64, reserved *int64) error {
+	if max := this.cache.maxEntrySize; max > 0 && *reserved+n > max {
+		return fmt.Errorf("entry size exceeds limit of %d bytes", max)
+	}
+	if err := this.cache.reserve(this.key, n); err != nil {
+		return err
+	}
+	*reserved += n
 	return nil
 }
 
-func (this *cacheAction) _bytes() ([]byte, error) {
-	if fileExists(this.base) {
-		buf := &bytes.Buffer{}
-		file, err := os.OpenFile(this.base, os.O_RDONLY, 0660)
-		if err != nil {
-			this.cache.remove(this.base)
-			return nil, err
+// _state determines the freshness of the cache entry.
+func (this *cacheAction) _state(policy *CachePolicy) (int, ResourceMeta) {
+	if !fileExists(this.base) {
+		return entryMissing, nil
+	}
+	meta := ResourceMeta{}
+	meta.Read(this.base)
+	return policy.state(meta.Age(this.base)), meta
+}
+
+// _usable checks whether the cache entry can be used without
+// revalidation. Stale entries still usable trigger a background
+// revalidation.
+func (this *cacheAction) _usable(policy *CachePolicy) bool {
+	state, _ := this._state(policy)
+	switch state {
+	case entryFresh:
+		return true
+	case entryRevalidate:
+		this.cache.revalidate(this, policy)
+		return true
+	}
+	return false
+}
+
+// revalidation is an ongoing revalidation of a cache entry.
+type revalidation struct {
+	done chan struct{}
+	err  error
+}
+
+// revalidate checks a stale cache entry with the origin. The upstream
+// request is executed without holding the lock, concurrent revalidations
+// of the entry are joined. Modified content is filled in the background.
+func (this *cacheAction) revalidate(policy *CachePolicy) error {
+	this.Lock()
+	if r := this.validation; r != nil {
+		this.Unlock()
+		<-r.done
+		return r.err
+	}
+	state, meta := this._state(policy)
+	if this.filling != nil || this.url == nil || (state != entryRevalidate && state != entryStale) {
+		this.Unlock()
+		return nil
+	}
+	r := &revalidation{done: make(chan struct{})}
+	this.validation = r
+	this.Unlock()
+
+	r.err = this.check(meta, policy)
+
+	this.Lock()
+	this.validation = nil
+	this.Unlock()
+	close(r.done)
+	return r.err
+}
+
+// check executes a conditional request for the cached content described
+// by the given meta data and applies the result to the cache entry.
+func (this *cacheAction) check(meta ResourceMeta, policy *CachePolicy) error {
+	this.cache.Infof("revalidating %s [%s]", this.url, this.base)
+	resp, err := this.get(meta)
+	if err != nil {
+		return err
+	}
+
+	this.Lock()
+	defer this.Unlock()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		resp.Body.Close()
+		cacheRevalidations.Inc("unmodified")
+		current := ResourceMeta{}
+		current.Read(this.base)
+		if this.filling != nil || current[CONTENT_FETCHED] != meta[CONTENT_FETCHED] {
+			// the entry has been replaced in the meantime
+			return nil
 		}
-		defer file.Close()
-		var tmp [8096]byte
-		for {
-			n, err := file.Read(tmp[:])
-			if n > 0 {
-				buf.Write(tmp[:n])
-			}
-			if err != nil {
-				if err == io.EOF {
-					return buf.Bytes(), nil
-				}
-				return nil, err
-			}
-			if n < 0 {
-				return buf.Bytes(), nil
-			}
+		meta.SetFetched(time.Now())
+		if err := meta.Write(this.base); err != nil {
+			this.cache.Warnf("cannot update meta data for %s: %s", this.url, err)
 		}
+		return nil
+	case http.StatusOK:
+		cacheRevalidations.Inc("modified")
+		_, err = this._startFill(resp, policy)
+		return err
+	default:
+		resp.Body.Close()
+		cacheRevalidations.Inc("failed")
+		return fmt.Errorf("URL get failed: %s", resp.Status)
 	}
-	return nil, nil
 }
 
-func (this *cacheAction) bytes() ([]byte, error) {
+// _open opens the cached content. The read lock must be held.
+func (this *cacheAction) _open() (*cacheContent, error) {
+	file, err := os.Open(this.base)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	meta := ResourceMeta{}
+	meta.Read(this.base)
+	touch(this.base)
+	return &cacheContent{file: file, meta: meta, url: this.url, cache: this.cache, base: this.base}, nil
+}
+
+// open provides usable cached content or the content currently filled.
+func (this *cacheAction) open(policy *CachePolicy) (*cacheContent, error) {
 	this.RLock()
 	defer this.RUnlock()
-	return this._bytes()
+	if this.filling != nil {
+		return this.filling.open()
+	}
+	if this._usable(policy) {
+		return this._open()
+	}
+	return nil, nil
+}
+
+// _prepare provides usable cached content or starts a fill if required.
+// It requires the write lock.
+func (this *cacheAction) _prepare(policy *CachePolicy) (*cacheContent, error) {
+	if this.filling != nil {
+		cacheHits.Inc()
+		return this.filling.open()
+	}
+	if this._usable(policy) {
+		c, err := this._open()
+		if c != nil || err != nil {
+			cacheHits.Inc()
+			return c, err
+		}
+	}
+	cacheMisses.Inc()
+	if _, err := this._startFill(nil, policy); err != nil {
+		return nil, err
+	}
+	return this.filling.open()
 }
 
-func (this *cacheAction) Bytes() ([]byte, error) {
+func (this *cacheAction) content(policy *CachePolicy) (*cacheContent, error) {
+	for retry := true; ; retry = false {
+		c, err := this.lookup(policy)
+		if err != nil || len(policy.Digests) == 0 {
+			return c, err
+		}
+		err = this.verify(c, policy)
+		if err == nil {
+			return c, nil
+		}
+		c.Close()
+		if c.fill != nil {
+			if old := this.fallback(c.fill, policy); old != nil {
+				if err := this.verify(old, policy); err != nil {
+					old.Close()
+					return nil, err
+				}
+				return old, nil
+			}
+			return nil, err
+		}
+		if !retry {
+			return nil, err
+		}
+		// the mismatching entry has been removed, try to refill it
+	}
+}
 
-	data, err := this.bytes()
-	if data != nil || err != nil {
-		return data, err
+func (this *cacheAction) lookup(policy *CachePolicy) (*cacheContent, error) {
+	c, err := this.open(policy)
+	if c != nil || err != nil {
+		cacheHits.Inc()
+		return c, err
 	}
 
+	err = this.revalidate(policy)
+
 	this.Lock()
 	defer this.Unlock()
+	if err != nil && this.filling == nil {
+		if state, _ := this._state(policy); state == entryStale {
+			if !this._usableOnError(policy) {
+				this.cache.remove(this.base)
+				return nil, err
+			}
+			this.cache.Warnf("revalidation of %s failed, serving stale content: %s", this.url, err)
+			cacheHits.Inc()
+			return this._open()
+		}
+	}
+	return this._prepare(policy)
+}
 
-	data, err = this._bytes()
-	if data != nil || err != nil {
-		return data, err
+// verify checks the content against the expected digests. Content
+// currently filled is verified after the fill has been completed.
+// Mismatching cache entries are removed.
+func (this *cacheAction) verify(c *cacheContent, policy *CachePolicy) error {
+	var digests Digests
+	if c.fill != nil {
+		var err error
+		digests, err = c.fill.complete()
+		if err != nil {
+			return err
+		}
+		if digests == nil {
+			// passthrough, content is verified when served
+			c.digests = policy.Digests
+			return nil
+		}
+	} else {
+		digests = c.meta.Digests()
 	}
+	if err := policy.Digests.Check(digests); err != nil {
+		this.cache.Warnf("cached content of %s: %s", this.url, err)
+		this.Lock()
+		if this.filling == nil {
+			this.cache.remove(this.base)
+		}
+		this.Unlock()
+		return fmt.Errorf("content of %s: %s", this.url, err)
+	}
+	c.digests = digests
+	return nil
+}
 
-	buf := &bytes.Buffer{}
-	err = this.fill(buf)
+// prefetch assures a filled cache entry.
+func (this *cacheAction) prefetch(policy *CachePolicy) (*CacheInfo, error) {
+	c, err := this.content(policy)
 	if err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
-}
-
-func (this *cacheAction) _serve(w http.ResponseWriter, r *http.Request) bool {
-	if fileExists(this.base) {
-		meta := ResourceMeta{}
-		meta.Read(this.base)
-		if meta[CONTENT_TYPE] != "" {
-			w.Header().Set(CONTENT_TYPE, meta[CONTENT_TYPE])
+	defer c.Close()
+	if c.fill != nil {
+		digests, err := c.fill.complete()
+		if err != nil {
+			return nil, err
+		}
+		if digests == nil {
+			return nil, fmt.Errorf("content of %s cannot be cached", this.url)
 		}
-		http.ServeFile(w, r, this.base)
-		return true
 	}
-	return false
-}
 
-func (this *cacheAction) serve(w http.ResponseWriter, r *http.Request) bool {
 	this.RLock()
 	defer this.RUnlock()
-	return this._serve(w, r)
+	if !fileExists(this.base) {
+		return nil, fmt.Errorf("content of %s not cached", this.url)
+	}
+	meta := ResourceMeta{}
+	meta.Read(this.base)
+	return meta.Info(), nil
 }
 
-func (this *cacheAction) Serve(w http.ResponseWriter, r *http.Request) {
-	if this.serve(w, r) {
-		return
+func (this *cacheAction) Bytes(policy *CachePolicy) ([]byte, error) {
+	c, err := this.content(policy)
+	if err != nil {
+		return nil, err
 	}
+	data, err := c.Bytes()
+	c.Close()
+	if err != nil && c.fill != nil {
+		if old := this.fallback(c.fill, policy); old != nil {
+			defer old.Close()
+			return old.Bytes()
+		}
+	}
+	return data, err
+}
 
-	this.Lock()
-	defer this.Unlock()
-
-	if this._serve(w, r) {
+// Serve serves the cached content. If a fill replacing a previous entry
+// fails before any content has been sent, the previous entry is served.
+func (this *cacheAction) Serve(policy *CachePolicy, w http.ResponseWriter, r *http.Request) {
+	c, err := this.content(policy)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(err.Error() + "\n"))
 		return
 	}
-
-	this.fill(w)
+	if c.fill != nil {
+		if _, _, _, err := c.fill.header(); err != nil {
+			if old := this.fallback(c.fill, policy); old != nil {
+				c.Close()
+				c = old
+			}
+		}
+	}
+	defer c.Close()
+	c.Serve(w, r)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 
+const CONTENT_ETAG = "Etag"
+const CONTENT_LAST_MODIFIED = "Last-Modified"
+const CONTENT_FETCHED = "Fetched"
+const CONTENT_SIZE = "Size"
+const CONTENT_DIGEST_PREFIX = "Digest-"
+const CONTENT_POLICY_MAX_AGE = "Policy-Max-Age"
+const CONTENT_POLICY_STALE_WHILE_REVALIDATE = "Policy-Stale-While-Revalidate"
+const CONTENT_POLICY_STALE_IF_ERROR = "Policy-Stale-If-Error"
+const CONTENT_POLICY_DIGEST_PREFIX = "Policy-Digest-"
+
 type ResourceMeta map[string]string
 
-func (this ResourceMeta) Write(base string) {
-	file, err := os.OpenFile(cachemeta(base), os.O_WRONLY|os.O_CREATE, 0660)
-	if err == nil {
-		defer file.Close()
-		for k, v := range this {
-			file.WriteString(fmt.Sprintf("%s: %s\n", k, v))
+func (this ResourceMeta) SetDigests(digests Digests) {
+	for alg, v := range digests {
+		this[CONTENT_DIGEST_PREFIX+alg] = v
+	}
+}
+
+// SetPolicy records the policy a cache entry has been filled with.
+func (this ResourceMeta) SetPolicy(policy *CachePolicy) {
+	this[CONTENT_POLICY_MAX_AGE] = policy.MaxAge.String()
+	this[CONTENT_POLICY_STALE_WHILE_REVALIDATE] = policy.StaleWhileRevalidate.String()
+	this[CONTENT_POLICY_STALE_IF_ERROR] = policy.StaleIfError.String()
+	for alg, v := range policy.Digests {
+		this[CONTENT_POLICY_DIGEST_PREFIX+alg] = v
+	}
+}
+
+// Policy provides the policy recorded for a cache entry. If no
+// policy is recorded, false is returned.
+func (this ResourceMeta) Policy() (CachePolicy, bool) {
+	policy := CachePolicy{}
+	if _, ok := this[CONTENT_POLICY_MAX_AGE]; !ok {
+		return policy, false
+	}
+	policy.MaxAge, _ = time.ParseDuration(this[CONTENT_POLICY_MAX_AGE])
+	policy.StaleWhileRevalidate, _ = time.ParseDuration(this[CONTENT_POLICY_STALE_WHILE_REVALIDATE])
+	policy.StaleIfError, _ = time.ParseDuration(this[CONTENT_POLICY_STALE_IF_ERROR])
+	for k, v := range this {
+		if strings.HasPrefix(k, CONTENT_POLICY_DIGEST_PREFIX) {
+			if policy.Digests == nil {
+				policy.Digests = Digests{}
+			}
+			policy.Digests[k[len(CONTENT_POLICY_DIGEST_PREFIX):]] = v
+		}
+	}
+	return policy, true
+}
+
+func (this ResourceMeta) Digests() Digests {
+	digests := Digests{}
+	for k, v := range this {
+		if strings.HasPrefix(k, CONTENT_DIGEST_PREFIX) {
+			digests[k[len(CONTENT_DIGEST_PREFIX):]] = v
+		}
+	}
+	return digests
+}
+
+// Info provides the cache entry description for the meta data.
+func (this ResourceMeta) Info() *CacheInfo {
+	info := &CacheInfo{
+		URL:         this[CONTENT_URL],
+		Size:        this.Size(),
+		ContentType: this[CONTENT_TYPE],
+		Digests:     this.Digests(),
+	}
+	if t, err := time.Parse(time.RFC3339Nano, this[CONTENT_FETCHED]); err == nil {
+		info.Fetched = t
+	}
+	return info
+}
+
+func (this ResourceMeta) SetFetched(t time.Time) {
+	this[CONTENT_FETCHED] = t.UTC().Format(time.RFC3339Nano)
+}
+
+// Age provides the time since the content has been fetched
+// or validated with the origin.
+func (this ResourceMeta) Age(base string) time.Duration {
+	if t, err := time.Parse(time.RFC3339Nano, this[CONTENT_FETCHED]); err == nil {
+		return time.Since(t)
+	}
+	if info, err := os.Stat(base); err == nil {
+		return time.Since(info.ModTime())
+	}
+	return 0
+}
+
+// Write atomically writes the meta data for a cache entry.
+func (this ResourceMeta) Write(base string) error {
+	path := cachemeta(base)
+	tmp := cachetemp(path)
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		return err
+	}
+	for k, v := range this {
+		if _, err = file.WriteString(fmt.Sprintf("%s: %s\n", k, v)); err != nil {
+			break
 		}
 	}
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Size provides the recorded content size, or -1 if not available.
+func (this ResourceMeta) Size() int64 {
+	if size, err := strconv.ParseInt(this[CONTENT_SIZE], 10, 64); err == nil {
+		return size
+	}
+	return -1
 }
 
 func (this ResourceMeta) Read(base string) {
@@ -315,7 +908,7 @@ func (this ResourceMeta) Read(base string) {
 			if !strings.HasPrefix(line, "#") {
 				i := strings.Index(line, ": ")
 				if i > 0 {
-					this[line[:i]] = line[i+2:]
+					this[line[:i]] = strings.TrimRight(line[i+2:], "\r\n")
 				}
 			}
 			if err != nil {
@@ -331,7 +924,40 @@ func cachemeta(base string) string {
 	return base + ".meta"
 }
 func iscachemeta(base string) bool {
-	return strings.HasPrefix(base, ".meta")
+	return strings.HasSuffix(base, ".meta")
+}
+func cachetemp(base string) string {
+	return base + ".tmp"
+}
+func iscachetemp(base string) bool {
+	return strings.HasSuffix(base, ".tmp")
+}
+func cachevariant(base string) string {
+	return base + ".gz"
+}
+func iscachevariant(base string) bool {
+	return strings.HasSuffix(base, ".gz")
+}
+
+// syncDir flushes directory changes (like renames) to disk.
+func syncDir(path string) {
+	if dir, err := os.Open(path); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+}
+
+// touch marks a cache entry as recently used. The access time is
+// explicitly set to be independent of the mount options of the cache volume.
+func touch(path string) {
+	if info, err := os.Stat(path); err == nil {
+		os.Chtimes(path, time.Now(), info.ModTime())
+	}
+}
+
+func accessTime(info os.FileInfo) time.Time {
+	ts := info.Sys().(*syscall.Stat_t).Atim
+	return time.Unix(int64(ts.Sec), int64(ts.Nsec))
 }
 
 func NewDirectoryCache(logger logger.LogContext, path string) (*DirCache, error) {
@@ -339,21 +965,269 @@ func NewDirectoryCache(logger logger.LogContext, path string) (*DirCache, error)
 	if err != nil {
 		return nil, err
 	}
-	return &DirCache{
+	cache := &DirCache{
 		LogContext: logger.NewContext("server", "cache"),
 		path:       path,
 		actions:    map[string]*cacheAction{},
-	}, nil
+	}
+	cache.verify()
+	caches.add(cache)
+	return cache, nil
 }
+
+// verify checks the integrity of the cache directory. Left over
+// temporary files, orphaned meta data and incomplete entries are removed.
+// Entries without a recorded size cannot be verified and are removed, too.
+func (this *DirCache) verify() {
+	files, err := ioutil.ReadDir(this.path)
+	if err != nil {
+		return
+	}
+	dropped := 0
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		fpath := filepath.Join(this.path, f.Name())
+		switch {
+		case iscachetemp(f.Name()):
+			this.Infof("removing partial cache file %s", f.Name())
+			os.Remove(fpath)
+		case iscachemeta(f.Name()):
+			if !fileExists(strings.TrimSuffix(fpath, ".meta")) {
+				this.Infof("removing orphaned meta data %s", f.Name())
+				os.Remove(fpath)
+			}
+		case iscachevariant(f.Name()):
+			if !fileExists(strings.TrimSuffix(fpath, ".gz")) {
+				this.Infof("removing orphaned compressed variant %s", f.Name())
+				os.Remove(fpath)
+			}
+		default:
+			meta := ResourceMeta{}
+			meta.Read(fpath)
+			size := meta.Size()
+			switch {
+			case size < 0:
+				// entries of older versions may be truncated, they cannot be verified
+				this.Infof("removing cache entry %s without recorded size", f.Name())
+				os.Remove(fpath)
+				os.Remove(cachemeta(fpath))
+				dropped++
+			case size != f.Size():
+				this.Infof("removing incomplete cache entry %s [%d/%d bytes]", f.Name(), f.Size(), size)
+				os.Remove(fpath)
+				os.Remove(cachemeta(fpath))
+				dropped++
+			}
+		}
+	}
+	if dropped > 0 {
+		this.Warnf("%d incomplete or unverifiable cache entries removed", dropped)
+	}
+	syncDir(this.path)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.scan()
+}
+
 func (this *DirCache) remove(base string) {
-	os.Remove(base)
+	for _, path := range []string{base, cachevariant(base)} {
+		if info, err := os.Stat(path); err == nil {
+			atomic.AddInt64(&this.size, -info.Size())
+		}
+		os.Remove(path)
+	}
 	os.Remove(cachemeta(base))
 }
 
-func (this *DirCache) release(key string) {
+// SetPrecompression configures the minimum size of compressible
+// cached content a gzip compressed variant is kept for (0 disables
+// compressed variants).
+func (this *DirCache) SetPrecompression(minSize int64) {
+	this.precompress = minSize
+}
+
+// variant provides the gzip compressed variant of complete cached content.
+// The variant is created on demand. It is tagged with the digest of
+// the content to detect outdated variants.
+func (this *DirCache) variant(c *cacheContent, info os.FileInfo) (*os.File, error) {
+	tag := c.meta.Digests()[DIGEST_SHA256]
+	if tag == "" {
+		return nil, fmt.Errorf("no digest for %s", c.base)
+	}
+	path := cachevariant(c.base)
+	if file, err := os.Open(path); err == nil {
+		if r, err := gzip.NewReader(file); err == nil && r.Comment == tag {
+			if _, err = file.Seek(0, io.SeekStart); err == nil {
+				return file, nil
+			}
+		}
+		file.Close()
+	}
+
+	tmp, err := ioutil.TempFile(this.path, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(tmp)
+	gz.Comment = tag
+	_, err = io.Copy(gz, io.NewSectionReader(c.file, 0, info.Size()))
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	tmp.Close()
+	if err == nil {
+		if old, err := os.Stat(path); err == nil {
+			atomic.AddInt64(&this.size, -old.Size())
+		}
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if vinfo, err := file.Stat(); err == nil {
+		atomic.AddInt64(&this.size, vinfo.Size())
+		this.Infof("compressed variant for %s [%d -> %d bytes]", filepath.Base(c.base), info.Size(), vinfo.Size())
+	}
+	return file, nil
+}
+
+// discard removes a temporary file of a fill.
+func (this *DirCache) discard(path string) {
+	if info, err := os.Stat(path); err == nil {
+		atomic.AddInt64(&this.size, -info.Size())
+	}
+	os.Remove(path)
+}
+
+// SetQuota configures the maximum size of the cache and of a single
+// cache entry (0 means unlimited). If the cache size is exceeded
+// least recently used entries not in use are evicted.
+func (this *DirCache) SetQuota(maxSize, maxEntrySize int64) {
 	this.lock.Lock()
 	defer this.lock.Unlock()
-	delete(this.actions, key)
+	this.maxSize = maxSize
+	this.maxEntrySize = maxEntrySize
+	this.scan()
+	if size := atomic.LoadInt64(&this.size); this.maxSize > 0 && size > this.maxSize {
+		this.evict(size-this.maxSize, "")
+	}
+}
+
+type cacheEntry struct {
+	key    string
+	size   int64
+	access time.Time
+}
+
+// scan determines the cached entries and the actual cache size.
+// The cache lock must be held.
+func (this *DirCache) scan() []*cacheEntry {
+	files, err := ioutil.ReadDir(this.path)
+	if err != nil {
+		return nil
+	}
+	size := int64(0)
+	entries := []*cacheEntry{}
+	for _, f := range files {
+		if f.IsDir() || iscachemeta(strings.TrimSuffix(f.Name(), ".tmp")) {
+			continue
+		}
+		size += f.Size()
+		if iscachetemp(f.Name()) || iscachevariant(f.Name()) {
+			// files of ongoing fills and compressed variants occupy
+			// space but cannot be evicted on their own
+			continue
+		}
+		entries = append(entries, &cacheEntry{f.Name(), f.Size(), accessTime(f)})
+	}
+	atomic.StoreInt64(&this.size, size)
+	return entries
+}
+
+// evict removes least recently used entries until the given amount
+// of space is freed. Entries in use and the entry with the given key
+// are protected. The cache lock must be held.
+func (this *DirCache) evict(needed int64, key string) int64 {
+	entries := this.scan()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].access.Before(entries[j].access) })
+	freed := int64(0)
+	for _, e := range entries {
+		if freed >= needed {
+			break
+		}
+		if e.key == key || this.actions[e.key] != nil {
+			continue
+		}
+		this.Infof("evict %s [%d bytes]", e.key, e.size)
+		this.remove(filepath.Join(this.path, e.key))
+		freed += e.size
+	}
+	return freed
+}
+
+var errNoQuota = fmt.Errorf("cache quota exceeded")
+
+// reserve reserves space for a cache entry. If the quota would be exceeded,
+// least recently used entries are evicted.
+func (this *DirCache) reserve(key string, n int64) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.maxSize > 0 {
+		if needed := this.used() + n - this.maxSize; needed > 0 {
+			this.evict(needed, key)
+			if this.used()+n > this.maxSize {
+				return errNoQuota
+			}
+		}
+	}
+	atomic.AddInt64(&this.pending, n)
+	return nil
+}
+
+func (this *DirCache) used() int64 {
+	return atomic.LoadInt64(&this.size) + atomic.LoadInt64(&this.pending)
+}
+
+// written adds written content to the cache size. The reserved
+// part is taken from the pending reservations.
+func (this *DirCache) written(n, reserved int64) {
+	atomic.AddInt64(&this.pending, -reserved)
+	atomic.AddInt64(&this.size, n)
+}
+
+// use marks a cache action as used by an ongoing fill.
+func (this *DirCache) use(action *cacheAction) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	action.usecount++
+}
+
+func (this *DirCache) unreserve(n int64) {
+	if n != 0 {
+		atomic.AddInt64(&this.pending, -n)
+	}
+}
+
+// release releases a usage of a cache action. Unused actions are removed.
+// The use count is only changed under the cache lock, so an action
+// concurrently acquired again by getAction is never removed.
+func (this *DirCache) release(action *cacheAction) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	action.usecount--
+	if action.usecount <= 0 && this.actions[action.key] == action {
+		delete(this.actions, action.key)
+	}
 }
 
 func (this *DirCache) GetAction(url *url.URL) *CacheAction {
@@ -380,7 +1254,7 @@ func (this *DirCache) getAction(key string, url *url.URL) *CacheAction {
 		}
 		this.actions[key] = action
 	} else {
-		if url != nil {
+		if url != nil && action.url == nil {
 			action.url = url
 		}
 	}
@@ -389,15 +1263,64 @@ func (this *DirCache) getAction(key string, url *url.URL) *CacheAction {
 }
 
 func (this *DirCache) Bytes(url *url.URL) ([]byte, error) {
+	return this.bytes(url, &this.policy)
+}
+
+func (this *DirCache) Serve(url *url.URL, w http.ResponseWriter, r *http.Request) {
+	this.serve(url, &this.policy, w, r)
+}
+
+func (this *DirCache) bytes(url *url.URL, policy *CachePolicy) ([]byte, error) {
 	action := this.GetAction(url)
 	defer action.Done()
-	return action.Bytes()
+	return action.ref.Bytes(policy)
 }
 
-func (this *DirCache) Serve(url *url.URL, w http.ResponseWriter, r *http.Request) {
+func (this *DirCache) serve(url *url.URL, policy *CachePolicy, w http.ResponseWriter, r *http.Request) {
 	action := this.GetAction(url)
 	defer action.Done()
-	action.Serve(w, r)
+	action.ref.Serve(policy, w, r)
+}
+
+func (this *DirCache) Prefetch(url *url.URL) (*CacheInfo, error) {
+	return this.prefetch(url, &this.policy)
+}
+
+func (this *DirCache) prefetch(url *url.URL, policy *CachePolicy) (*CacheInfo, error) {
+	action := this.GetAction(url)
+	defer action.Done()
+	return action.ref.prefetch(policy)
+}
+
+// SetPolicy sets the default revalidation policy of the cache.
+func (this *DirCache) SetPolicy(policy CachePolicy) {
+	this.policy = policy
+}
+
+func (this *DirCache) Policy() CachePolicy {
+	return this.policy
+}
+
+func (this *DirCache) WithPolicy(policy CachePolicy) Cache {
+	return &policyCache{this, policy}
+}
+
+// revalidate triggers a background revalidation for a cache entry.
+// Only one revalidation is done at a time for an entry.
+func (this *DirCache) revalidate(action *cacheAction, policy *CachePolicy) {
+	if action.url == nil || !atomic.CompareAndSwapInt32(&action.revalidating, 0, 1) {
+		return
+	}
+	p := *policy
+	url := action.url
+	go func() {
+		defer atomic.StoreInt32(&action.revalidating, 0)
+		a := this.GetAction(url)
+		defer a.Done()
+		if err := a.ref.revalidate(&p); err != nil {
+			this.Warnf("revalidation of %s failed: %s", url, err)
+		}
+	}()
 }
 
 func (this *DirCache) Cleanup(logger logger.LogContext, duration time.Duration) {
@@ -411,22 +1334,36 @@ func (this *DirCache) Cleanup(logger logger.LogContext, duration time.Duration)
 
 	now := time.Now()
 	for _, f := range files {
+		if iscachemeta(f.Name()) || iscachetemp(f.Name()) || iscachevariant(f.Name()) {
+			continue
+		}
 		action := this.GetActionForKey(f.Name())
 		action.Execute(func() {
-			if !iscachemeta(f.Name()) {
-				fpath := filepath.Join(this.path, f.Name())
-				finfo, err := os.Stat(fpath)
-				if err == nil {
-					ts := finfo.Sys().(*syscall.Stat_t).Atim
-					t := time.Unix(int64(ts.Sec), int64(ts.Nsec))
-
-					now.Sub(t)
-					if now.Sub(t) > duration {
-						logger.Infof("cleanup %s [%s]", f.Name(), now.Sub(t))
-						this.remove(fpath)
-					}
+			action.ref.Lock()
+			defer action.ref.Unlock()
+			if action.ref.filling != nil {
+				return
+			}
+			fpath := filepath.Join(this.path, f.Name())
+			finfo, err := os.Stat(fpath)
+			if err == nil {
+				t := accessTime(finfo)
+				if now.Sub(t) > duration {
+					logger.Infof("cleanup %s [%s]", f.Name(), now.Sub(t))
+					this.remove(fpath)
 				}
 			}
 		})
+		action.Done()
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.scan()
+	if this.maxSize > 0 {
+		if needed := this.used() - this.maxSize; needed > 0 {
+			logger.Infof("cache size exceeds quota by %d bytes", needed)
+			this.evict(needed, "")
+		}
 	}
 }
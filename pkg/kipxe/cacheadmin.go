@@ -0,0 +1,285 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kipxe
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+)
+
+var ErrCacheEntryNotFound = fmt.Errorf("cache entry not found")
+var ErrCacheEntryBusy = fmt.Errorf("cache entry is currently filled")
+
+func validCacheKey(key string) bool {
+	data, err := hex.DecodeString(key)
+	return err == nil && len(data) == sha1.Size
+}
+
+// Entries lists the complete entries of the cache.
+func (this *DirCache) Entries() []*CacheInfo {
+	files, err := ioutil.ReadDir(this.path)
+	if err != nil {
+		return nil
+	}
+	entries := []*CacheInfo{}
+	for _, f := range files {
+		if f.IsDir() || !validCacheKey(f.Name()) {
+			continue
+		}
+		entries = append(entries, this.info(f))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].URL < entries[j].URL })
+	return entries
+}
+
+// Entry describes the cache entry for a key.
+func (this *DirCache) Entry(key string) (*CacheInfo, error) {
+	if !validCacheKey(key) {
+		return nil, ErrCacheEntryNotFound
+	}
+	f, err := os.Stat(filepath.Join(this.path, key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrCacheEntryNotFound
+		}
+		return nil, err
+	}
+	return this.info(f), nil
+}
+
+func (this *DirCache) info(f os.FileInfo) *CacheInfo {
+	meta := ResourceMeta{}
+	meta.Read(filepath.Join(this.path, f.Name()))
+	info := meta.Info()
+	info.Key = f.Name()
+	info.Size = f.Size()
+	info.LastAccess = accessTime(f)
+	return info
+}
+
+// Purge removes the cache entry for a key. Entries currently
+// filled cannot be purged.
+func (this *DirCache) Purge(key string) error {
+	if !validCacheKey(key) {
+		return ErrCacheEntryNotFound
+	}
+	action := this.GetActionForKey(key)
+	defer action.Done()
+	a := action.ref
+	a.Lock()
+	defer a.Unlock()
+	if a.filling != nil {
+		return ErrCacheEntryBusy
+	}
+	if !fileExists(a.base) {
+		return ErrCacheEntryNotFound
+	}
+	this.Infof("purge %s", key)
+	this.remove(a.base)
+	return nil
+}
+
+// PurgeAll removes all cache entries not currently filled.
+// It returns the number of purged and skipped entries.
+func (this *DirCache) PurgeAll() (int, int) {
+	purged := 0
+	busy := 0
+	for _, e := range this.Entries() {
+		switch this.Purge(e.Key) {
+		case nil:
+			purged++
+		case ErrCacheEntryBusy:
+			busy++
+		}
+	}
+	return purged, busy
+}
+
+// Refill purges the cache entry for a URL and fills it again
+// in the background. The entry is filled with the policy (expected
+// digests and freshness) recorded for the purged entry. Without such an
+// entry the default policy of the cache is used.
+func (this *DirCache) Refill(u *url.URL) error {
+	key := Hash(u.String())
+	policy := this.policy
+	meta := ResourceMeta{}
+	meta.Read(filepath.Join(this.path, key))
+	if p, ok := meta.Policy(); ok {
+		policy = p
+	}
+	err := this.Purge(key)
+	if err != nil && err != ErrCacheEntryNotFound {
+		return err
+	}
+	go func() {
+		if _, err := this.prefetch(u, &policy); err != nil {
+			this.Warnf("refill of %s failed: %s", u, err)
+		}
+	}()
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// CacheAdminHandler serves the administration API for a directory cache.
+// Entries are addressed by their key or by the query parameter url.
+//
+//	GET    <path>/           list all entries
+//	DELETE <path>/           purge all entries
+//	GET    <path>/<key>      describe an entry
+//	DELETE <path>/<key>      purge an entry
+//	POST   <path>/<key>      purge and refill an entry
+type CacheAdminHandler struct {
+	logger.LogContext
+	path  string
+	cache *DirCache
+	token string
+}
+
+func NewCacheAdminHandler(logger logger.LogContext, path string, cache *DirCache, token string) http.Handler {
+	if !strings.HasSuffix(path, "/") {
+		path = path + "/"
+	}
+	return &CacheAdminHandler{
+		LogContext: logger.NewContext("server", "cacheadmin"),
+		path:       path,
+		cache:      cache,
+		token:      token,
+	}
+}
+
+func (this *CacheAdminHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	err := this.serve(w, req)
+	if err != nil {
+		this.Error(err)
+	}
+}
+
+func (this *CacheAdminHandler) serve(w http.ResponseWriter, req *http.Request) error {
+	if !bearerAuthenticated(req, this.token) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="kipxe"`)
+		return this.error(w, http.StatusUnauthorized, "unauthorized")
+	}
+	if !strings.HasPrefix(req.URL.Path, this.path) {
+		return this.error(w, http.StatusNotFound, "invalid resource")
+	}
+	key := req.URL.Path[len(this.path):]
+	query := req.URL.Query()
+	var u *url.URL
+	if raw := query.Get("url"); raw != "" {
+		if key != "" {
+			return this.error(w, http.StatusBadRequest, "key and url given")
+		}
+		var err error
+		u, err = url.Parse(raw)
+		if err != nil {
+			return this.error(w, http.StatusBadRequest, "invalid url %q: %s", raw, err)
+		}
+		key = Hash(u.String())
+		query.Del("url")
+	}
+	if len(query) > 0 {
+		return this.error(w, http.StatusBadRequest, "unexpected query parameters")
+	}
+
+	if key == "" {
+		switch req.Method {
+		case http.MethodGet:
+			return this.json(w, http.StatusOK, this.cache.Entries())
+		case http.MethodDelete:
+			purged, busy := this.cache.PurgeAll()
+			this.Infof("purged %d cache entries (%d busy)", purged, busy)
+			return this.json(w, http.StatusOK, map[string]int{"purged": purged, "busy": busy})
+		}
+		return this.error(w, http.StatusMethodNotAllowed, "method %s not allowed", req.Method)
+	}
+
+	if !validCacheKey(key) {
+		return this.error(w, http.StatusNotFound, "invalid cache key %q", key)
+	}
+	switch req.Method {
+	case http.MethodGet:
+		info, err := this.cache.Entry(key)
+		if err != nil {
+			return this.failed(w, err)
+		}
+		return this.json(w, http.StatusOK, info)
+	case http.MethodDelete:
+		if err := this.cache.Purge(key); err != nil {
+			return this.failed(w, err)
+		}
+		return this.json(w, http.StatusOK, map[string]string{"purged": key})
+	case http.MethodPost:
+		if u == nil {
+			info, err := this.cache.Entry(key)
+			if err != nil {
+				return this.failed(w, err)
+			}
+			u, err = url.Parse(info.URL)
+			if err != nil || info.URL == "" {
+				return this.error(w, http.StatusConflict, "no valid URL for cache entry %s", key)
+			}
+		}
+		if err := this.cache.Refill(u); err != nil {
+			return this.failed(w, err)
+		}
+		return this.json(w, http.StatusAccepted, map[string]string{"refill": u.String(), "key": key})
+	}
+	return this.error(w, http.StatusMethodNotAllowed, "method %s not allowed", req.Method)
+}
+
+func (this *CacheAdminHandler) failed(w http.ResponseWriter, err error) error {
+	switch err {
+	case ErrCacheEntryNotFound:
+		return this.error(w, http.StatusNotFound, "%s", err)
+	case ErrCacheEntryBusy:
+		return this.error(w, http.StatusConflict, "%s", err)
+	}
+	return this.error(w, http.StatusInternalServerError, "%s", err)
+}
+
+func (this *CacheAdminHandler) json(w http.ResponseWriter, status int, obj interface{}) error {
+	data, err := MarshalJSON(obj)
+	if err != nil {
+		return this.error(w, http.StatusInternalServerError, "%s", err)
+	}
+	w.Header().Set(CONTENT_TYPE, MIME_JSON)
+	w.WriteHeader(status)
+	w.Write(data)
+	return nil
+}
+
+func (this *CacheAdminHandler) error(w http.ResponseWriter, status int, msg string, args ...interface{}) error {
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(msg + "\n"))
+	return ErrorString(msg)
+}
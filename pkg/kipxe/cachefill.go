@@ -0,0 +1,322 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kipxe
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// cacheFill describes an ongoing cache fill. There is only one
+// fill (and upstream request) for a cache entry at a time. All readers
+// stream the content from the cache file while it is written.
+type cacheFill struct {
+	lock sync.Mutex
+	cond *sync.Cond
+	path string
+	url  *url.URL
+
+	meta        ResourceMeta
+	length      int64
+	written     int64
+	started     bool
+	passthrough bool
+	done        bool
+	err         error
+
+	// keep is only used by the filler to decide whether
+	// the content is kept in the cache
+	keep bool
+	// replacing indicates an existing cache entry replaced by the fill
+	replacing bool
+	// expected digests to verify and the digests of the
+	// completely written content
+	expected Digests
+	digests  Digests
+}
+
+func newCacheFill(path string, url *url.URL, expected Digests) *cacheFill {
+	fill := &cacheFill{
+		path:     path,
+		url:      url,
+		length:   -1,
+		expected: expected,
+	}
+	fill.cond = sync.NewCond(&fill.lock)
+	return fill
+}
+
+func (this *cacheFill) start(meta ResourceMeta, length int64, passthrough bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.meta = meta
+	this.length = length
+	this.passthrough = passthrough
+	this.started = true
+	this.cond.Broadcast()
+}
+
+func (this *cacheFill) progress(n int64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.written += n
+	this.cond.Broadcast()
+}
+
+func (this *cacheFill) finish(err error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.done = true
+	this.err = err
+	this.cond.Broadcast()
+}
+
+// failed checks whether the fill has been completed with an error.
+func (this *cacheFill) failed() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.done && this.err != nil
+}
+
+// header waits until the content description is available.
+func (this *cacheFill) header() (ResourceMeta, int64, bool, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for !this.started && !this.done {
+		this.cond.Wait()
+	}
+	if !this.started {
+		if this.err == nil {
+			return nil, 0, false, fmt.Errorf("cache fill failed")
+		}
+		return nil, 0, false, this.err
+	}
+	return this.meta, this.length, this.passthrough, nil
+}
+
+// wait waits until more content than the given offset is available
+// or the fill is done.
+func (this *cacheFill) wait(offset int64) (int64, bool, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for this.written <= offset && !this.done {
+		this.cond.Wait()
+	}
+	return this.written, this.done, this.err
+}
+
+// complete waits for the completion of the fill and provides the
+// digests of the content. For passed through content no digests are
+// available.
+func (this *cacheFill) complete() (Digests, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for !this.done {
+		this.cond.Wait()
+	}
+	if this.err != nil {
+		return nil, this.err
+	}
+	if this.passthrough {
+		return nil, nil
+	}
+	return this.digests, nil
+}
+
+// open opens the content for a reader. It must be called under the
+// lock of the cache action to assure the existence of the file.
+func (this *cacheFill) open() (*cacheContent, error) {
+	file, err := os.Open(this.path)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheContent{file: file, url: this.url, fill: this}, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// cacheContent is an opened cache entry. It is either complete or
+// still filled.
+type cacheContent struct {
+	file *os.File
+	meta ResourceMeta
+	url  *url.URL
+	fill *cacheFill
+	// verified digests (or expected digests for passed through content)
+	digests Digests
+	// cache and base path of complete content
+	cache *DirCache
+	base  string
+}
+
+func (this *cacheContent) Close() error {
+	return this.file.Close()
+}
+
+func (this *cacheContent) setHeader(w http.ResponseWriter, meta ResourceMeta) {
+	if meta[CONTENT_TYPE] != "" {
+		w.Header().Set(CONTENT_TYPE, meta[CONTENT_TYPE])
+	}
+	if meta[CONTENT_ETAG] != "" {
+		w.Header().Set(CONTENT_ETAG, meta[CONTENT_ETAG])
+	}
+	digests := this.digests
+	if digests == nil {
+		digests = meta.Digests()
+	}
+	digests.SetHeader(w)
+}
+
+func (this *cacheContent) Serve(w http.ResponseWriter, r *http.Request) {
+	if this.fill == nil {
+		this.setHeader(w, this.meta)
+		info, err := this.file.Stat()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if this.compressed(r, info) {
+			variant, err := this.cache.variant(this, info)
+			if err == nil {
+				defer variant.Close()
+				w.Header().Set(HEADER_CONTENT_ENCODING, ENCODING_GZIP)
+				w.Header().Add(HEADER_VARY, HEADER_ACCEPT_ENCODING)
+				weakETag(w.Header())
+				http.ServeContent(w, r, "", info.ModTime(), variant)
+				return
+			}
+			this.cache.Warnf("cannot provide compressed variant for %s: %s", this.url, err)
+		}
+		http.ServeContent(w, r, "", info.ModTime(), this.file)
+		return
+	}
+
+	meta, length, passthrough, err := this.fill.header()
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	if passthrough {
+		if len(this.digests) > 0 {
+			data, err := this.passthrough()
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				w.Write([]byte(err.Error() + "\n"))
+				return
+			}
+			this.setHeader(w, meta)
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.WriteHeader(http.StatusOK)
+			if r.Method != http.MethodHead {
+				w.Write(data)
+			}
+			return
+		}
+		NewURLSource("", this.url, nil).Serve(w, r)
+		return
+	}
+	this.setHeader(w, meta)
+	if length >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	this.stream(w)
+}
+
+func (this *cacheContent) Bytes() ([]byte, error) {
+	if this.fill != nil {
+		_, _, passthrough, err := this.fill.header()
+		if err != nil {
+			return nil, err
+		}
+		if passthrough {
+			return this.passthrough()
+		}
+	}
+	buf := &bytes.Buffer{}
+	err := this.stream(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// compressed checks whether a precompressed variant should be served.
+func (this *cacheContent) compressed(r *http.Request, info os.FileInfo) bool {
+	if this.cache == nil || this.cache.precompress <= 0 || info.Size() < this.cache.precompress {
+		return false
+	}
+	if r.Header.Get("Range") != "" || !AcceptsGzip(r) {
+		return false
+	}
+	return Compressible(this.meta[CONTENT_TYPE])
+}
+
+// passthrough requests the content from the origin. If digests
+// are expected, it is verified.
+func (this *cacheContent) passthrough() ([]byte, error) {
+	data, err := NewURLSource("", this.url, nil).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if err := this.digests.Verify(data); err != nil {
+		return nil, fmt.Errorf("content of %s: %s", this.url, err)
+	}
+	return data, nil
+}
+
+// stream copies the content to a writer. For content still
+// filled it waits for more content until the fill is done.
+func (this *cacheContent) stream(w io.Writer) error {
+	var tmp [8196]byte
+	offset := int64(0)
+	for {
+		n, err := this.file.Read(tmp[:])
+		if n > 0 {
+			if err := write(w, tmp[:n]); err != nil {
+				return err
+			}
+			offset += int64(n)
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if this.fill == nil {
+			return nil
+		}
+		written, done, err := this.fill.wait(offset)
+		if err != nil {
+			return err
+		}
+		if done && written <= offset {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,278 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kipxe
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const HEADER_ACCEPT_ENCODING = "Accept-Encoding"
+const HEADER_CONTENT_ENCODING = "Content-Encoding"
+const HEADER_VARY = "Vary"
+
+const ENCODING_GZIP = "gzip"
+const ENCODING_ZSTD = "zstd"
+
+// encodings lists the supported content encodings in order of preference
+// for equally weighted codings.
+var encodings = []string{ENCODING_ZSTD, ENCODING_GZIP}
+
+// Compressible checks whether content of the given mime type should be
+// compressed. This is the case for textual content like iPXE scripts,
+// JSON or YAML documents. Binary content is never compressed.
+func Compressible(mimeType string) bool {
+	mt, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mt, "text/"):
+		return true
+	case strings.HasSuffix(mt, "+json"), strings.HasSuffix(mt, "+xml"), strings.HasSuffix(mt, "+yaml"):
+		return true
+	}
+	switch mt {
+	case MIME_JSON, MIME_XML, MIME_YAML, MIME_SHELL, "application/yaml", "application/javascript":
+		return true
+	}
+	return false
+}
+
+// AcceptsGzip checks whether the client accepts gzip encoded content.
+func AcceptsGzip(r *http.Request) bool {
+	return acceptedEncodings(r)[ENCODING_GZIP] > 0
+}
+
+// AcceptedEncoding determines the supported content encoding with the
+// highest quality accepted by the client. If none is accepted an empty
+// string is returned.
+func AcceptedEncoding(r *http.Request) string {
+	accepted := acceptedEncodings(r)
+	found := ""
+	for _, e := range encodings {
+		if accepted[e] > accepted[found] {
+			found = e
+		}
+	}
+	return found
+}
+
+// acceptedEncodings provides the quality of the supported encodings
+// according to the Accept-Encoding headers of a request.
+func acceptedEncodings(r *http.Request) map[string]float64 {
+	explicit := map[string]float64{}
+	wildcard := -1.0
+	for _, h := range r.Header.Values(HEADER_ACCEPT_ENCODING) {
+		for _, e := range strings.Split(h, ",") {
+			parts := strings.Split(e, ";")
+			coding := strings.ToLower(strings.TrimSpace(parts[0]))
+			q := 1.0
+			for _, p := range parts[1:] {
+				p = strings.TrimSpace(p)
+				if strings.HasPrefix(p, "q=") {
+					v, err := strconv.ParseFloat(p[2:], 64)
+					if err != nil {
+						v = 0
+					}
+					q = v
+				}
+			}
+			if coding == "*" {
+				wildcard = q
+			} else {
+				explicit[coding] = q
+			}
+		}
+	}
+	result := map[string]float64{}
+	for _, e := range encodings {
+		if q, ok := explicit[e]; ok {
+			result[e] = q
+		} else if wildcard > 0 {
+			result[e] = wildcard
+		}
+	}
+	return result
+}
+
+// weakETag converts an entity tag for an encoded representation.
+func weakETag(h http.Header) {
+	if etag := h.Get(CONTENT_ETAG); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set(CONTENT_ETAG, "W/"+etag)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type compressionHandler struct {
+	handler http.Handler
+	minSize int64
+}
+
+// NewCompressionHandler provides a handler negotiating zstd or gzip
+// compression for compressible responses of at least the given size.
+func NewCompressionHandler(handler http.Handler, minSize int64) http.Handler {
+	return &compressionHandler{
+		handler: handler,
+		minSize: minSize,
+	}
+}
+
+func (this *compressionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodHead {
+		this.handler.ServeHTTP(w, r)
+		return
+	}
+	encoding := AcceptedEncoding(r)
+	if encoding == "" {
+		this.handler.ServeHTTP(w, r)
+		return
+	}
+	cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: this.minSize}
+	defer cw.Close()
+	this.handler.ServeHTTP(cw, r)
+}
+
+// encoder is the common interface of the supported compressors.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func newEncoder(encoding string, w io.Writer) (encoder, error) {
+	switch encoding {
+	case ENCODING_ZSTD:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	default:
+		return gzip.NewWriter(w), nil
+	}
+}
+
+// compressWriter defers the decision about the compression of a response
+// until the content is known to be large enough.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int64
+	status   int
+	buf      []byte
+	decided  bool
+	enc      encoder
+}
+
+func (this *compressWriter) compressible() bool {
+	h := this.Header()
+	if h.Get(HEADER_CONTENT_ENCODING) != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	if !Compressible(h.Get(CONTENT_TYPE)) {
+		return false
+	}
+	h.Add(HEADER_VARY, HEADER_ACCEPT_ENCODING)
+	return true
+}
+
+func (this *compressWriter) decide(compress bool) {
+	this.decided = true
+	if compress {
+		enc, err := newEncoder(this.encoding, this.ResponseWriter)
+		if err == nil {
+			h := this.Header()
+			h.Del("Content-Length")
+			h.Del("Accept-Ranges")
+			h.Set(HEADER_CONTENT_ENCODING, this.encoding)
+			weakETag(h)
+			this.enc = enc
+		}
+	}
+	if this.status != 0 {
+		this.ResponseWriter.WriteHeader(this.status)
+	}
+	if len(this.buf) > 0 {
+		this.write(this.buf)
+		this.buf = nil
+	}
+}
+
+func (this *compressWriter) write(data []byte) (int, error) {
+	if this.enc != nil {
+		return this.enc.Write(data)
+	}
+	return this.ResponseWriter.Write(data)
+}
+
+func (this *compressWriter) WriteHeader(status int) {
+	if this.decided || this.status != 0 {
+		return
+	}
+	this.status = status
+	if status != http.StatusOK || !this.compressible() {
+		this.decide(false)
+		return
+	}
+	if l := this.Header().Get("Content-Length"); l != "" {
+		size, err := strconv.ParseInt(l, 10, 64)
+		this.decide(err == nil && size >= this.minSize)
+	}
+}
+
+func (this *compressWriter) Write(data []byte) (int, error) {
+	if !this.decided {
+		if this.status == 0 {
+			this.WriteHeader(http.StatusOK)
+		}
+	}
+	if this.decided {
+		return this.write(data)
+	}
+	this.buf = append(this.buf, data...)
+	if int64(len(this.buf)) >= this.minSize {
+		this.decide(true)
+	}
+	return len(data), nil
+}
+
+func (this *compressWriter) Flush() {
+	if !this.decided && (this.status != 0 || len(this.buf) > 0) {
+		this.decide(int64(len(this.buf)) >= this.minSize && this.compressible())
+	}
+	if this.enc != nil {
+		this.enc.Flush()
+	}
+	if f, ok := this.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (this *compressWriter) Close() error {
+	if !this.decided && (this.status != 0 || len(this.buf) > 0) {
+		this.decide(false)
+	}
+	if this.enc != nil {
+		return this.enc.Close()
+	}
+	return nil
+}
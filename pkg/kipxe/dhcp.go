@@ -0,0 +1,110 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kipxe
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/ghodss/yaml"
+
+	"github.com/mandelsoft/kipxe/pkg/dhcp"
+)
+
+const DHCP_RESOURCE_PATH = "dhcp"
+
+// DHCPHandler determines the boot information for ProxyDHCP requests
+// by the matching engine. Therefore a dedicated resource path
+// (by default `dhcp`) is requested with metadata derived from the DHCP request:
+// - `mac`: the hardware address of the client
+// - `uuid`: the machine uuid (option 97)
+// - `arch`: the client system architecture (option 93)
+// - `user-class`: the user class (option 77), for example `iPXE`
+// - `vendor-class`: the vendor class identifier (option 60)
+// The content of the resource is the boot file name. For JSON or YAML
+// resources the fields `filename` and `nextServer` are used.
+type DHCPHandler struct {
+	infobase *InfoBase
+	path     string
+}
+
+var _ dhcp.Handler = &DHCPHandler{}
+
+func NewDHCPHandler(infobase *InfoBase, path string) dhcp.Handler {
+	if path == "" {
+		path = DHCP_RESOURCE_PATH
+	}
+	return &DHCPHandler{infobase: infobase, path: path}
+}
+
+type dhcpBootInfo struct {
+	Filename   string `json:"filename,omitempty"`
+	NextServer string `json:"nextServer,omitempty"`
+}
+
+func (this *DHCPHandler) BootInfo(logger logger.LogContext, req *dhcp.Packet, remote *net.UDPAddr) (*dhcp.BootInfo, error) {
+	values := map[string][]string{}
+	values["mac"] = []string{req.CHAddr.String()}
+	if uuid := req.UUID(); uuid != "" {
+		values["uuid"] = []string{uuid}
+	}
+	if arch := req.Arch(); arch >= 0 {
+		values["arch"] = []string{strconv.Itoa(arch)}
+	}
+	if uc := req.Options.String(dhcp.OPT_USER_CLASS); uc != "" {
+		values["user-class"] = []string{uc}
+	}
+	values["vendor-class"] = []string{req.Options.String(dhcp.OPT_VENDOR_CLASS)}
+
+	origin := remote.IP
+	if !req.CIAddr.Equal(net.IPv4zero) {
+		origin = req.CIAddr
+	}
+	metadata := NewRequestMetaData(this.path, origin.String(), values)
+	logger.Infof("request %s: %s", this.path, metadata)
+
+	source, err := this.infobase.GetSource(logger, metadata, this.path, nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err := source.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	switch MimeType(source.MimeType()) {
+	case MIME_JSON, MIME_YAML:
+		info := &dhcpBootInfo{}
+		if err := yaml.Unmarshal(data, info); err != nil {
+			return nil, fmt.Errorf("invalid boot info: %s", err)
+		}
+		result := &dhcp.BootInfo{Filename: info.Filename}
+		if info.NextServer != "" {
+			result.NextServer = net.ParseIP(info.NextServer).To4()
+			if result.NextServer == nil {
+				return nil, fmt.Errorf("invalid next server address %q: IPv4 address required", info.NextServer)
+			}
+		}
+		return result, nil
+	default:
+		return &dhcp.BootInfo{Filename: strings.TrimSpace(string(data))}, nil
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kipxe
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const DIGEST_SHA256 = "sha256"
+const DIGEST_SHA512 = "sha512"
+
+const HEADER_DIGEST = "Digest"
+
+var digestAlgorithms = map[string]func() hash.Hash{
+	DIGEST_SHA256: sha256.New,
+	DIGEST_SHA512: sha512.New,
+}
+
+// header names of the algorithms according to RFC 3230
+var digestHeaderNames = map[string]string{
+	DIGEST_SHA256: "sha-256",
+	DIGEST_SHA512: "sha-512",
+}
+
+// Digests describes hex encoded content digests by algorithm.
+type Digests map[string]string
+
+// NewDigests validates and normalizes the given digests.
+func NewDigests(digests map[string]string) (Digests, error) {
+	result := Digests{}
+	for alg, v := range digests {
+		if v == "" {
+			continue
+		}
+		h, ok := digestAlgorithms[alg]
+		if !ok {
+			return nil, fmt.Errorf("unknown digest algorithm %q", alg)
+		}
+		v = strings.ToLower(v)
+		if d, err := hex.DecodeString(v); err != nil || len(d) != h().Size() {
+			return nil, fmt.Errorf("invalid %s digest %q", alg, v)
+		}
+		result[alg] = v
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// ComputeDigests determines all supported digests for some content.
+func ComputeDigests(data []byte) Digests {
+	d := newDigester()
+	d.Write(data)
+	return d.Digests()
+}
+
+// Check verifies the given actual digests against the expected ones.
+func (this Digests) Check(actual Digests) error {
+	for _, alg := range this.algorithms() {
+		if actual[alg] == "" {
+			return fmt.Errorf("%s digest not available", alg)
+		}
+		if actual[alg] != this[alg] {
+			return fmt.Errorf("%s digest mismatch: expected %s, found %s", alg, this[alg], actual[alg])
+		}
+	}
+	return nil
+}
+
+// Verify verifies the expected digests for some content.
+func (this Digests) Verify(data []byte) error {
+	if len(this) == 0 {
+		return nil
+	}
+	return this.Check(ComputeDigests(data))
+}
+
+// ETag provides an entity tag derived from the digests.
+func (this Digests) ETag() string {
+	for _, alg := range this.algorithms() {
+		return fmt.Sprintf("\"%s:%s\"", alg, this[alg])
+	}
+	return ""
+}
+
+// Header provides the value for a Digest header (RFC 3230).
+func (this Digests) Header() string {
+	var values []string
+	for _, alg := range this.algorithms() {
+		d, err := hex.DecodeString(this[alg])
+		if err == nil {
+			values = append(values, digestHeaderNames[alg]+"="+base64.StdEncoding.EncodeToString(d))
+		}
+	}
+	return strings.Join(values, ",")
+}
+
+// SetHeader sets the Digest header for the digests.
+func (this Digests) SetHeader(w http.ResponseWriter) {
+	if h := this.Header(); h != "" {
+		w.Header().Set(HEADER_DIGEST, h)
+	}
+}
+
+func (this Digests) algorithms() []string {
+	var algs []string
+	for alg := range this {
+		if digestAlgorithms[alg] != nil {
+			algs = append(algs, alg)
+		}
+	}
+	sort.Strings(algs)
+	return algs
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// digester calculates all supported digests for a stream of content.
+type digester map[string]hash.Hash
+
+func newDigester() digester {
+	d := digester{}
+	for alg, h := range digestAlgorithms {
+		d[alg] = h()
+	}
+	return d
+}
+
+func (this digester) Write(data []byte) (int, error) {
+	for _, h := range this {
+		h.Write(data)
+	}
+	return len(data), nil
+}
+
+func (this digester) Digests() Digests {
+	result := Digests{}
+	for alg, h := range this {
+		result[alg] = hex.EncodeToString(h.Sum(nil))
+	}
+	return result
+}
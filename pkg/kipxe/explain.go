@@ -0,0 +1,230 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kipxe
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/gardener/controller-manager-library/pkg/types/infodata/simple"
+)
+
+// Explanation is the trace of the matching pipeline for a request.
+type Explanation struct {
+	Version     uint64           `json:"version"`
+	Path        string           `json:"path"`
+	Metadata    MetaData         `json:"metadata"`
+	Mappers     []MapperTrace    `json:"mappers,omitempty"`
+	Matchers    []MatcherTrace   `json:"matchers,omitempty"`
+	Profiles    []ProfileTrace   `json:"profiles,omitempty"`
+	Deliverable *DeliverableInfo `json:"deliverable,omitempty"`
+	Stages      []StageTrace     `json:"stages,omitempty"`
+	MimeType    string           `json:"mimeType,omitempty"`
+	Status      int              `json:"status"`
+	Error       string           `json:"error,omitempty"`
+}
+
+// MapperTrace describes the metadata after the execution of a metadata mapper.
+type MapperTrace struct {
+	Mapper   string   `json:"mapper"`
+	Weight   int      `json:"weight"`
+	Metadata MetaData `json:"metadata,omitempty"`
+	Note     string   `json:"note,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// MatcherTrace describes the match result of a matcher.
+type MatcherTrace struct {
+	Matcher string `json:"matcher"`
+	Profile string `json:"profile"`
+	Weight  int    `json:"weight"`
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// ProfileTrace describes a profile searched for the requested resource.
+type ProfileTrace struct {
+	Matcher string `json:"matcher"`
+	Profile string `json:"profile"`
+	Found   bool   `json:"found"`
+}
+
+// DeliverableInfo describes the chosen deliverable of a profile.
+type DeliverableInfo struct {
+	Profile  string   `json:"profile"`
+	Document string   `json:"document"`
+	Captures []string `json:"captures,omitempty"`
+}
+
+// StageTrace describes the intermediate values after a mapping stage.
+type StageTrace struct {
+	Stage  string        `json:"stage"`
+	Name   string        `json:"name"`
+	Values simple.Values `json:"values,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+func NewExplanation(path string, metadata MetaData) *Explanation {
+	return &Explanation{
+		Path:     path,
+		Metadata: metadata.DeepCopy(),
+	}
+}
+
+func (this *Explanation) mapped(m MetaDataMapper, values MetaData, note string, err error) {
+	t := MapperTrace{
+		Mapper: stringOf(m),
+		Weight: m.Weight(),
+		Note:   note,
+	}
+	if err != nil {
+		t.Error = err.Error()
+	} else {
+		t.Metadata = values.DeepCopy()
+	}
+	this.Mappers = append(this.Mappers, t)
+}
+
+// the following trace methods can be called on a nil explanation to
+// keep the regular request processing free of trace handling.
+
+func (this *Explanation) profile(matcher *BootProfileMatcher, profile Name, found bool) {
+	if this == nil {
+		return
+	}
+	this.Profiles = append(this.Profiles, ProfileTrace{
+		Matcher: matcher.Name().String(),
+		Profile: profile.String(),
+		Found:   found,
+	})
+}
+
+func (this *Explanation) deliverable(profile Name, d *Deliverable, captures []string) {
+	if this == nil {
+		return
+	}
+	this.Deliverable = &DeliverableInfo{
+		Profile:  profile.String(),
+		Document: d.Name().String(),
+		Captures: captures,
+	}
+}
+
+func (this *Explanation) stage(stage string, name Name, intermediate Intermediate, err error) {
+	if this == nil {
+		return
+	}
+	t := StageTrace{
+		Stage: stage,
+		Name:  name.String(),
+	}
+	if err == nil && intermediate != nil {
+		var v simple.Values
+		v, err = intermediate.Values()
+		t.Values = v.DeepCopy()
+	}
+	if err != nil {
+		t.Error = err.Error()
+	}
+	this.Stages = append(this.Stages, t)
+}
+
+func (this *Explanation) done(source Source, err error) {
+	if err != nil {
+		this.Status = StatusCode(err)
+		this.Error = err.Error()
+		return
+	}
+	this.Status = http.StatusOK
+	this.MimeType = source.MimeType()
+}
+
+func stringOf(o interface{}) string {
+	if s, ok := o.(interface{ String() string }); ok {
+		return s.String()
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", o), "*")
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ExplainHandler serves the matching trace for resource paths
+// as JSON document. Requests must be authenticated by a bearer token.
+type ExplainHandler struct {
+	Handler
+	token string
+}
+
+func NewExplainHandler(logger logger.LogContext, path string, infobase *InfoBase, token string) http.Handler {
+	if !strings.HasSuffix(path, "/") {
+		path = path + "/"
+	}
+	return &ExplainHandler{
+		Handler: Handler{
+			LogContext: logger.NewContext("server", "explain"),
+			path:       path,
+			infobase:   infobase,
+		},
+		token: token,
+	}
+}
+
+func (this *ExplainHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	err := this.serve(w, req)
+	if err != nil {
+		this.Error(err)
+	}
+}
+
+func (this *ExplainHandler) serve(w http.ResponseWriter, req *http.Request) error {
+	if !bearerAuthenticated(req, this.token) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="kipxe"`)
+		return this.error(w, http.StatusUnauthorized, "unauthorized")
+	}
+	if !strings.HasPrefix(req.URL.Path, this.path) {
+		return this.error(w, http.StatusNotFound, "invalid resource")
+	}
+
+	// the credentials must not show up in the request metadata
+	r := req.Clone(req.Context())
+	r.Header.Del("Authorization")
+
+	metadata, path := this.requestMetadata(r)
+	explain := this.infobase.Explain(this, metadata, path, r)
+
+	data, err := MarshalJSON(explain)
+	if err != nil {
+		return this.error(w, http.StatusInternalServerError, "%s", err)
+	}
+	w.Header().Set(CONTENT_TYPE, MIME_JSON)
+	w.Write(data)
+	return nil
+}
+
+// bearerAuthenticated checks the bearer token of a request.
+func bearerAuthenticated(req *http.Request, token string) bool {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(auth[7:])), []byte(token)) == 1
+}
@@ -22,22 +22,66 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
-	"github.com/gardener/controller-manager-library/pkg/convert"
 	"github.com/gardener/controller-manager-library/pkg/logger"
-	"github.com/gardener/controller-manager-library/pkg/types"
-	"github.com/gardener/controller-manager-library/pkg/types/infodata/simple"
 )
 
 const MACHINE_FOUND = "MACHINE-FOUND"
 const REQUEST_REJECT = "REQUEST-REJECT"
 
+// paths of the server endpoints below the base path
+const PATH_READY = "ready"
+const PATH_EXPLAIN = "explain"
+const PATH_CACHE = "cache"
+const PATH_INVENTORY = "inventory"
+
+// ReservedPaths are the paths of the server endpoints. They and the
+// paths below them cannot be used for deliverables of profiles.
+var ReservedPaths = []string{PATH_READY, PATH_EXPLAIN, PATH_CACHE, PATH_INVENTORY}
+
+// ReservedPath provides the reserved endpoint path covering a resource
+// path, or an empty string, if the path is not reserved.
+func ReservedPath(path string) string {
+	for _, r := range ReservedPaths {
+		if path == r || strings.HasPrefix(path, r+"/") {
+			return r
+		}
+	}
+	return ""
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
 type ErrorString string
 
 func (e ErrorString) Error() string { return string(e) }
 
+// StatusError is an error for a request providing
+// an appropriate HTTP status code.
+type StatusError struct {
+	status int
+	msg    string
+}
+
+func NewStatusError(status int, msg string, args ...interface{}) error {
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	return &StatusError{status, msg}
+}
+
+func (e *StatusError) Error() string { return e.msg }
+func (e *StatusError) Status() int   { return e.status }
+
+// StatusCode returns the HTTP status code for an error.
+func StatusCode(err error) int {
+	if s, ok := err.(*StatusError); ok {
+		return s.status
+	}
+	return http.StatusInternalServerError
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
 type Handler struct {
@@ -67,129 +111,44 @@ func (this *Handler) error(w http.ResponseWriter, status int, msg string, args .
 }
 
 func (this *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	err := this.serve(w, req)
+	start := time.Now()
+	recorder := &statusRecorder{ResponseWriter: w}
+	defer recorder.observe(start)
+
+	err := this.serve(recorder, req)
 	if err != nil {
 		this.Error(err)
 	}
 }
 
 func (this *Handler) requestMetadata(req *http.Request) (MetaData, string) {
-	metadata := MetaData{}
-	raw := req.URL.Query()
-
 	path := req.URL.Path[len(this.path):]
-	metadata["RESOURCE_PATH"] = path
-
 	host := strings.Split(req.RemoteAddr, ":")[0]
-	metadata["ORIGIN"] = host
-	fill(metadata, raw)
-	fill(metadata, req.Header)
+	metadata := NewRequestMetaData(path, host, req.URL.Query(), req.Header)
 
 	this.Infof("request %s: %s", path, metadata)
 	return metadata, path
 }
 
 func (this *Handler) serve(w http.ResponseWriter, req *http.Request) error {
-	var err error
-
 	if !strings.HasPrefix(req.URL.Path, this.path) {
 		return this.error(w, http.StatusNotFound, "invalid resource")
 	}
 
 	metadata, path := this.requestMetadata(req)
-
-	if this.infobase.Registry != nil {
-		metadata, err = this.infobase.Registry.Map(this, metadata, req)
-		if err != nil {
-			return this.error(w, http.StatusBadRequest, "cannot map metadata: %s", err)
-		}
-		if s := convert.BestEffortString(metadata[REQUEST_REJECT]); s != "" {
-			return this.error(w, http.StatusNotAcceptable, "%s", s)
-		}
+	if r := ReservedPath(path); r != "" {
+		// served by the endpoint, if enabled
+		return this.error(w, http.StatusNotFound, "resource %s reserved for the %s endpoint", path, r)
 	}
 
-	this.Infof("matching %s", metadata)
-	list := this.infobase.Matchers.Match(this, metadata)
-	if len(list) == 0 {
-		this.Infof("no matcher found")
-		return this.error(w, http.StatusNotFound, "no matching matcher")
-	}
-
-	this.Infof("found %d matchers: %s", len(list), MatcherNameList(list))
-
-	for _, matcher := range list {
-		pname := matcher.ProfileName()
-		this.Infof("looking in matcher %s -> profile %s", matcher.Key(), pname)
-		profile := this.infobase.Profiles.Get(pname)
-		if profile == nil {
-			return this.error(w, http.StatusNotFound, "profile %q not found", pname)
-		}
-
-		deliverable, list := profile.GetDeliverableForPath(path)
-		if deliverable == nil {
-			continue
-		}
-
-		doc := this.infobase.Resources.Get(deliverable.Name())
-		if doc == nil {
-			return this.error(w, http.StatusNotFound, "document %q for profile %q resource %q not found", deliverable.Name(), pname, path)
-		}
-
-		this.Infof("found document %s in profile %s", deliverable.Name(), pname)
-
-		source := doc.GetSource()
-
-		if mappedsource, _ := source.(SourceMapper); !doc.skipProcessing || mappedsource != nil {
-			match_info := map[string]interface{}{}
-			resmatch := types.CopyAndNormalize(list)
-			if resmatch != nil {
-				match_info["resource"] = resmatch
-			}
-			match_info["document"] = deliverable.Name().String()
-			match_info["profile"] = pname.String()
-			match_info["matcher"] = matcher.Name().String()
-
-			metavalues := simple.Values{}
-			metadata["match-info"] = match_info
-			metavalues["<<<"] = "(( merge ))"
-			metavalues["metadata"] = metadata
-			intermediate := NewSimpleIntermediateValues(types.NormValues(simple.Values(metadata).DeepCopy()))
-			intermediate, err = mapit(fmt.Sprintf("matcher %s", matcher.Name()), matcher.GetMapping(), matcher.GetValues(), metavalues, intermediate)
-			if err != nil {
-				return this.error(w, http.StatusUnprocessableEntity, err.Error())
-			}
-			intermediate, err = mapit(fmt.Sprintf("profile %s", pname), profile.GetMapping(), profile.GetValues(), metavalues, intermediate)
-			if err != nil {
-				return this.error(w, http.StatusUnprocessableEntity, err.Error())
-			}
-			intermediate, err = mapit(fmt.Sprintf("profile %s, document %s", pname, deliverable.Name()), doc.GetMapping(), doc.GetValues(), metavalues, intermediate)
-			if err != nil {
-				return this.error(w, http.StatusUnprocessableEntity, err.Error())
-			}
-
-			v, err := intermediate.Values()
-			if err != nil {
-				return this.error(w, http.StatusUnprocessableEntity, err.Error())
-			}
-			if mappedsource != nil {
-				source, err = mappedsource.Map(v)
-				if err != nil {
-					return this.error(w, http.StatusUnprocessableEntity, err.Error())
-				}
-			}
-
-			if !doc.skipProcessing {
-				source, err = Process("document", v, source)
-				if err != nil {
-					return this.error(w, http.StatusUnprocessableEntity, err.Error())
-				}
-			}
-		}
-
-		source.Serve(w, req)
-		return nil
+	snapshot := this.infobase.Snapshot()
+	snapshot.SetHeader(w)
+	source, err := snapshot.GetSource(this, metadata, path, req)
+	if err != nil {
+		return this.error(w, StatusCode(err), "%s", err)
 	}
-	return this.error(w, http.StatusNotFound, "no resource %q found in matches", path)
+	source.Serve(w, req)
+	return nil
 }
 
 func fill(dst map[string]interface{}, src map[string][]string) {
@@ -18,11 +18,24 @@
 
 package kipxe
 
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const HEADER_CONFIG_VERSION = "X-Kipxe-Config-Version"
+
 type InfoBase struct {
 	Registry  *Registry
 	Resources *BootResources
 	Profiles  *BootProfiles
 	Matchers  *BootProfileMatchers
+
+	lock     sync.Mutex
+	version  uint64
+	snapshot atomic.Value
 }
 
 func (this *InfoBase) SetDocument(e *BootResource) NameSet {
@@ -39,3 +52,76 @@ func (this *InfoBase) SetProfile(e *BootProfile) (NameSet, error) {
 func (this *InfoBase) SetMatcher(e *BootProfileMatcher) error {
 	return this.Matchers.Set(e)
 }
+
+// Snapshot provides the actual configuration snapshot. If the matchers,
+// profiles or resources have been modified since the last snapshot,
+// a new one is created. It is published atomically and never modified
+// afterwards, so that a request can completely be resolved against
+// a consistent configuration.
+func (this *InfoBase) Snapshot() *Snapshot {
+	versions := this.versions()
+	if s, ok := this.snapshot.Load().(*Snapshot); ok && s.versions == versions {
+		return s
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	// lock order is given by the nesting of the element sets
+	this.Matchers.lock.RLock()
+	defer this.Matchers.lock.RUnlock()
+	this.Profiles.lock.RLock()
+	defer this.Profiles.lock.RUnlock()
+	this.Resources.lock.RLock()
+	defer this.Resources.lock.RUnlock()
+
+	versions = this.versions()
+	if s, ok := this.snapshot.Load().(*Snapshot); ok && s.versions == versions {
+		return s
+	}
+	this.version++
+	resources := this.Resources.snapshot()
+	profiles := this.Profiles.snapshot(resources)
+	s := &Snapshot{
+		version:   this.version,
+		versions:  versions,
+		registry:  this.Registry,
+		resources: resources,
+		profiles:  profiles,
+		matchers:  this.Matchers.snapshot(profiles),
+	}
+	this.snapshot.Store(s)
+	return s
+}
+
+func (this *InfoBase) versions() [3]uint64 {
+	return [3]uint64{
+		atomic.LoadUint64(&this.Matchers.version),
+		atomic.LoadUint64(&this.Profiles.version),
+		atomic.LoadUint64(&this.Resources.version),
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Snapshot is an immutable view of the matchers, profiles and resources
+// of an info base. The elements are shared among snapshots.
+type Snapshot struct {
+	version   uint64
+	versions  [3]uint64
+	registry  *Registry
+	resources *BootResources
+	profiles  *BootProfiles
+	matchers  *BootProfileMatchers
+}
+
+// Version provides the version of the snapshot, which is increased
+// for every new snapshot of an info base.
+func (this *Snapshot) Version() uint64 {
+	return this.version
+}
+
+// SetHeader announces the snapshot version used for a response.
+func (this *Snapshot) SetHeader(w http.ResponseWriter) {
+	w.Header().Set(HEADER_CONFIG_VERSION, strconv.FormatUint(this.version, 10))
+}
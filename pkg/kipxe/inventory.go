@@ -0,0 +1,267 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kipxe
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/gardener/controller-manager-library/pkg/types"
+)
+
+// MAX_INVENTORY_SIZE limits the size of uploaded inventory documents.
+const MAX_INVENTORY_SIZE = 1024 * 1024
+
+const LINK_UP = "up"
+const LINK_DOWN = "down"
+
+// Inventory is the hardware inventory of a machine reported
+// by a discovery ramdisk.
+type Inventory struct {
+	UUID   string            `json:"uuid,omitempty"`
+	CPUs   []InventoryCPU    `json:"cpus,omitempty"`
+	Memory []InventoryMemory `json:"memory,omitempty"`
+	Disks  []InventoryDisk   `json:"disks,omitempty"`
+	NICs   []InventoryNIC    `json:"nics,omitempty"`
+}
+
+type InventoryCPU struct {
+	Model string `json:"model,omitempty"`
+	Cores int    `json:"cores,omitempty"`
+	MHZ   int    `json:"mhz,omitempty"`
+}
+
+type InventoryMemory struct {
+	Size int64 `json:"size"`
+}
+
+type InventoryDisk struct {
+	Name  string `json:"name"`
+	Type  string `json:"type,omitempty"`
+	Model string `json:"model,omitempty"`
+	Size  int64  `json:"size,omitempty"`
+}
+
+type InventoryNIC struct {
+	Name  string `json:"name"`
+	MAC   string `json:"mac"`
+	Model string `json:"model,omitempty"`
+	Link  string `json:"link,omitempty"`
+	Speed int    `json:"speed,omitempty"`
+}
+
+// Validate checks the inventory and normalizes the MAC addresses.
+func (this *Inventory) Validate() error {
+	this.UUID = strings.TrimSpace(this.UUID)
+	if this.UUID == "" && len(this.NICs) == 0 {
+		return fmt.Errorf("uuid or at least one nic required")
+	}
+	for i, c := range this.CPUs {
+		if c.Cores < 0 || c.MHZ < 0 {
+			return fmt.Errorf("cpu %d: negative value", i)
+		}
+	}
+	for i, m := range this.Memory {
+		if m.Size < 0 {
+			return fmt.Errorf("memory %d: negative size", i)
+		}
+	}
+	for i, d := range this.Disks {
+		if d.Name == "" {
+			return fmt.Errorf("disk %d: name required", i)
+		}
+		if d.Size < 0 {
+			return fmt.Errorf("disk %s: negative size", d.Name)
+		}
+	}
+	macs := map[string]bool{}
+	for i := range this.NICs {
+		n := &this.NICs[i]
+		if n.Name == "" {
+			return fmt.Errorf("nic %d: name required", i)
+		}
+		hw, err := net.ParseMAC(n.MAC)
+		if err != nil {
+			return fmt.Errorf("nic %s: invalid mac %q", n.Name, n.MAC)
+		}
+		n.MAC = hw.String()
+		if macs[n.MAC] {
+			return fmt.Errorf("nic %s: duplicate mac %s", n.Name, n.MAC)
+		}
+		macs[n.MAC] = true
+		switch n.Link {
+		case "", LINK_UP, LINK_DOWN:
+		default:
+			return fmt.Errorf("nic %s: invalid link state %q", n.Name, n.Link)
+		}
+		if n.Speed < 0 {
+			return fmt.Errorf("nic %s: negative speed", n.Name)
+		}
+	}
+	return nil
+}
+
+var invalidKeyChars = regexp.MustCompile("[^a-z0-9_]+")
+
+// summaryKey provides a string usable as key in selectors and
+// spiff references.
+func summaryKey(s string) string {
+	s = strings.Trim(invalidKeyChars.ReplaceAllString(strings.ToLower(s), "_"), "_")
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// Summary provides values derived from the inventory suitable
+// for matchers, like the number of disks or NIC models.
+func (this *Inventory) Summary() map[string]interface{} {
+	cores := 0
+	for _, c := range this.CPUs {
+		cores += c.Cores
+	}
+	var memory int64
+	for _, m := range this.Memory {
+		memory += m.Size
+	}
+	diskTypes := map[string]interface{}{}
+	for _, d := range this.Disks {
+		k := summaryKey(d.Type)
+		diskTypes[k] = count(diskTypes[k]) + 1
+	}
+	nicModels := map[string]interface{}{}
+	linked := 0
+	for _, n := range this.NICs {
+		k := summaryKey(n.Model)
+		nicModels[k] = count(nicModels[k]) + 1
+		if n.Link == LINK_UP {
+			linked++
+		}
+	}
+	return map[string]interface{}{
+		"cpus":       len(this.CPUs),
+		"cores":      cores,
+		"memory":     memory,
+		"disks":      len(this.Disks),
+		"diskTypes":  diskTypes,
+		"nics":       len(this.NICs),
+		"linkedNics": linked,
+		"nicModels":  nicModels,
+	}
+}
+
+func count(v interface{}) int {
+	if i, ok := v.(int); ok {
+		return i
+	}
+	return 0
+}
+
+// Values provides the inventory including its summary as
+// normalized values.
+func (this *Inventory) Values() (map[string]interface{}, error) {
+	data, err := json.Marshal(this)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	values["summary"] = this.Summary()
+	return types.CopyAndNormalize(values).(map[string]interface{}), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// InventoryStore stores an inventory on the resource describing
+// the machine. It returns the name of the updated resource.
+type InventoryStore interface {
+	StoreInventory(logger logger.LogContext, inventory *Inventory) (string, error)
+}
+
+// InventoryHandler accepts hardware inventories uploaded with POST
+// requests as JSON documents. Requests must be authenticated by a
+// bearer token.
+type InventoryHandler struct {
+	logger.LogContext
+	store InventoryStore
+	token string
+}
+
+func NewInventoryHandler(logger logger.LogContext, store InventoryStore, token string) http.Handler {
+	return &InventoryHandler{
+		LogContext: logger.NewContext("server", "inventory"),
+		store:      store,
+		token:      token,
+	}
+}
+
+func (this *InventoryHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	err := this.serve(w, req)
+	if err != nil {
+		this.Error(err)
+	}
+}
+
+func (this *InventoryHandler) serve(w http.ResponseWriter, req *http.Request) error {
+	if !bearerAuthenticated(req, this.token) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="kipxe"`)
+		return this.error(w, http.StatusUnauthorized, "unauthorized")
+	}
+	if req.Method != http.MethodPost {
+		return this.error(w, http.StatusMethodNotAllowed, "method %s not allowed", req.Method)
+	}
+
+	inventory := &Inventory{}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, req.Body, MAX_INVENTORY_SIZE))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(inventory); err != nil {
+		return this.error(w, http.StatusBadRequest, "invalid inventory: %s", err)
+	}
+	if err := inventory.Validate(); err != nil {
+		return this.error(w, http.StatusBadRequest, "invalid inventory: %s", err)
+	}
+	name, err := this.store.StoreInventory(this, inventory)
+	if err != nil {
+		return this.error(w, StatusCode(err), "%s", err)
+	}
+	this.Infof("stored inventory for machine %s", name)
+	data, err := MarshalJSON(map[string]string{"machine": name})
+	if err != nil {
+		return this.error(w, http.StatusInternalServerError, "%s", err)
+	}
+	w.Header().Set(CONTENT_TYPE, MIME_JSON)
+	w.Write(data)
+	return nil
+}
+
+func (this *InventoryHandler) error(w http.ResponseWriter, status int, msg string, args ...interface{}) error {
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(msg + "\n"))
+	return ErrorString(msg)
+}
@@ -27,6 +27,7 @@ import (
 	"net/url"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/gardener/controller-manager-library/pkg/convert"
 	"github.com/gardener/controller-manager-library/pkg/logger"
@@ -40,6 +41,15 @@ type MetaDataMapper interface {
 	Map(logger logger.LogContext, values MetaData, req *http.Request) (MetaData, error)
 }
 
+// DryRunMetaDataMapper is implemented by metadata mappers with side
+// effects besides the mapping of the metadata. For traced mappings
+// DryRun is called instead of Map. It must not cause any side effect
+// and returns a note describing the omitted actions.
+type DryRunMetaDataMapper interface {
+	MetaDataMapper
+	DryRun(logger logger.LogContext, values MetaData, req *http.Request) (MetaData, string, error)
+}
+
 type MetaDataMappers []MetaDataMapper
 
 func (this MetaDataMappers) Len() int {
@@ -137,6 +147,18 @@ func (this *Registry) Weight() int {
 }
 
 func (this *Registry) Map(logger logger.LogContext, values MetaData, req *http.Request) (MetaData, error) {
+	return this.MapTraced(logger, values, req, nil)
+}
+
+// MapTraceFunc is called for every mapper executed by a registry
+// with the resulting metadata and the note of a dry run.
+type MapTraceFunc func(m MetaDataMapper, values MetaData, note string, err error)
+
+// MapTraced maps the metadata like Map, but reports the result
+// of every executed mapper. Nested registries are traced
+// mapper by mapper. Mappers with side effects are only executed
+// as dry run.
+func (this *Registry) MapTraced(logger logger.LogContext, values MetaData, req *http.Request, trace MapTraceFunc) (MetaData, error) {
 	this.lock.RLock()
 	defer this.lock.RUnlock()
 	var err error
@@ -144,7 +166,18 @@ func (this *Registry) Map(logger logger.LogContext, values MetaData, req *http.R
 	logger.Infof("found %d metadata mappers", len(this.registry))
 	for _, m := range this.registry {
 		logger.Infof("  mapping metadata with %s", m)
-		values, err = m.Map(logger, values, req)
+		if nested, ok := m.(*Registry); ok && trace != nil {
+			values, err = nested.MapTraced(logger, values, req, trace)
+		} else if dry, ok := m.(DryRunMetaDataMapper); ok && trace != nil {
+			var note string
+			values, note, err = dry.DryRun(logger, values, req)
+			trace(m, values, note, err)
+		} else {
+			values, err = m.Map(logger, values, req)
+			if trace != nil {
+				trace(m, values, "", err)
+			}
+		}
 		if err != nil {
 			logger.Errorf("mapping failed: %s", err)
 			break
@@ -221,6 +254,17 @@ func (this *urlMapper) Weight() int {
 }
 
 func (this *urlMapper) Map(logger logger.LogContext, values MetaData, req *http.Request) (MetaData, error) {
+	host := mapperHosts.Value(this.url.Host)
+	start := time.Now()
+	result, err := this.call(values)
+	urlMapperDuration.ObserveDuration(start, host)
+	if err != nil {
+		urlMapperErrors.Inc(host)
+	}
+	return result, err
+}
+
+func (this *urlMapper) call(values MetaData) (MetaData, error) {
 	data, err := MarshalJSON(values)
 	if err != nil {
 		return nil, err
@@ -45,7 +45,9 @@ func NewDefaultMapping(m yaml.Node) Mapping {
 func (this *defaultMapping) Map(name string, values, metavalues simple.Values, intermediate Intermediate) (Intermediate, error) {
 	var err error
 
-	intermediate = intermediate.Wrap()
+	if intermediate != nil {
+		intermediate = intermediate.Wrap()
+	}
 
 	inputs := []yaml.Node{}
 	err = this.AddStub(&inputs, fmt.Sprintf("%s:%s", name, "values"), values)
@@ -23,21 +23,41 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/gardener/controller-manager-library/pkg/logger"
 	"github.com/gardener/controller-manager-library/pkg/types/infodata/simple"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
 )
 
 type BootProfileMatchers struct {
 	lock     sync.RWMutex
 	elements map[string]*BootProfileMatcher
+	index    *matcherIndex
 	nested   *BootProfiles
+	version  uint64
 }
 
 func NewMatchers(nested *BootProfiles) *BootProfileMatchers {
 	return &BootProfileMatchers{
 		elements: map[string]*BootProfileMatcher{},
+		index:    newMatcherIndex(),
+		nested:   nested,
+	}
+}
+
+// snapshot provides an immutable copy of the matcher set
+// based on the given profile set.
+// The caller must hold the read lock.
+func (this *BootProfileMatchers) snapshot(nested *BootProfiles) *BootProfileMatchers {
+	elements := make(map[string]*BootProfileMatcher, len(this.elements))
+	for k, e := range this.elements {
+		elements[k] = e
+	}
+	return &BootProfileMatchers{
+		elements: elements,
+		index:    this.index.copy(),
 		nested:   nested,
 	}
 }
@@ -77,6 +97,7 @@ func (this *BootProfileMatchers) Set(m *BootProfileMatcher) error {
 	defer this.lock.Unlock()
 
 	key := m.Key()
+	atomic.AddUint64(&this.version, 1)
 	old := this.elements[key]
 	if old != nil {
 		if old.profile.String() != m.profile.String() {
@@ -84,6 +105,10 @@ func (this *BootProfileMatchers) Set(m *BootProfileMatcher) error {
 		}
 	}
 	this.nested.AddUser(m.ProfileName(), m.Name())
+	if old != nil {
+		this.index.remove(old)
+	}
+	this.index.add(m)
 	this.elements[key] = m
 	m.error = this.check(m)
 	return m.error
@@ -96,8 +121,10 @@ func (this *BootProfileMatchers) Delete(name Name) {
 	key := name.String()
 	old := this.elements[key]
 	if old != nil {
+		atomic.AddUint64(&this.version, 1)
 		delete(this.elements, key)
-		this.nested.Delete(old.profile)
+		this.index.remove(old)
+		this.nested.DeleteUser(old.profile, name)
 	}
 }
 
@@ -121,30 +148,40 @@ func NewMatcher(name Name, sel labels.Selector, matcher, mapping Mapping, values
 	}, nil
 }
 
-func (this BootProfileMatcher) PreferOver(m *BootProfileMatcher) bool {
+func (this *BootProfileMatcher) PreferOver(m *BootProfileMatcher) bool {
 	return this.Weight() > m.Weight() ||
 		(this.Weight() == m.Weight() && strings.Compare(this.Key(), m.Key()) < 0)
 }
 
-func (this BootProfileMatcher) Matches(logger logger.LogContext, meta MetaData) bool {
+func (this *BootProfileMatcher) Matches(logger logger.LogContext, meta MetaData) bool {
+	ok, _ := this.match(logger, meta)
+	return ok
+}
+
+// match checks the matcher for the given metadata and provides
+// the reason for a non-match.
+func (this *BootProfileMatcher) match(logger logger.LogContext, meta MetaData) (bool, string) {
 	if !this.selector.Matches(meta) {
-		return false
+		return false, fmt.Sprintf("selector %q does not match", this.selector)
 	}
 	if this.matcher != nil {
 		metavalues := simple.Values{"metadata": simple.Values(meta)}
 		r, err := this.matcher.Map("matcher", this.values, metavalues, nil)
 		if err != nil {
 			logger.Errorf("matcher %s failed: %s", this.Name(), err)
-			return false
+			return false, fmt.Sprintf("match expression failed: %s", err)
 		}
 		m := r.FieldValue("match")
 		if m != nil {
 			//logger.Infof("matcher %s: %v", this.name, m)
-			return toBool(m)
+			if toBool(m) {
+				return true, ""
+			}
+			return false, fmt.Sprintf("match expression evaluated to %v", m)
 		}
-		return false
+		return false, "match expression provides no match field"
 	}
-	return true
+	return true, ""
 }
 
 func (this *BootProfileMatcher) GetMapping() Mapping {
@@ -155,11 +192,11 @@ func (this *BootProfileMatcher) GetValues() simple.Values {
 	return this.values
 }
 
-func (this BootProfileMatcher) Weight() int {
+func (this *BootProfileMatcher) Weight() int {
 	return this.weight
 }
 
-func (this BootProfileMatcher) ProfileName() Name {
+func (this *BootProfileMatcher) ProfileName() Name {
 	return this.profile
 }
 
@@ -186,11 +223,174 @@ func (this *BootProfileMatchers) Match(logger logger.LogContext, meta MetaData)
 	defer this.lock.RUnlock()
 
 	var found []*BootProfileMatcher
-	for _, m := range this.elements {
+	this.index.candidates(meta, func(m *BootProfileMatcher) {
 		if m.Matches(logger, meta) {
 			found = append(found, m)
 		}
-	}
+	})
 	sort.Sort(BootProfileMatcherSlice(found))
 	return found
 }
+
+// MatchTraced matches like Match, but additionally reports the
+// result of all matchers in preference order.
+func (this *BootProfileMatchers) MatchTraced(logger logger.LogContext, meta MetaData) (BootProfileMatcherSlice, []MatcherTrace) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	all := BootProfileMatcherSlice{}
+	for _, m := range this.elements {
+		all = append(all, m)
+	}
+	sort.Sort(all)
+
+	var found []*BootProfileMatcher
+	trace := []MatcherTrace{}
+	for _, m := range all {
+		ok, reason := m.match(logger, meta)
+		if ok {
+			found = append(found, m)
+		}
+		trace = append(trace, MatcherTrace{
+			Matcher: m.Name().String(),
+			Profile: m.ProfileName().String(),
+			Weight:  m.Weight(),
+			Matched: ok,
+			Reason:  reason,
+		})
+	}
+	return found, trace
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// matcherIndex indexes matchers by one of the label equality requirements
+// of their selector (for example a UUID or MAC label). A request only has
+// to evaluate the matchers indexed for its own label values and
+// the matchers without any equality requirement.
+type matcherIndex struct {
+	labels map[string]map[string]map[string]*BootProfileMatcher
+	other  map[string]*BootProfileMatcher
+	keys   map[string]labelValue
+}
+
+type labelValue struct {
+	label string
+	value string
+}
+
+func newMatcherIndex() *matcherIndex {
+	return &matcherIndex{
+		labels: map[string]map[string]map[string]*BootProfileMatcher{},
+		other:  map[string]*BootProfileMatcher{},
+		keys:   map[string]labelValue{},
+	}
+}
+
+// equalities provides the label equality requirements of a selector.
+func equalities(sel labels.Selector) []labelValue {
+	if sel == nil {
+		return nil
+	}
+	reqs, selectable := sel.Requirements()
+	if !selectable {
+		return nil
+	}
+	var result []labelValue
+	for _, r := range reqs {
+		switch r.Operator() {
+		case selection.Equals, selection.DoubleEquals, selection.In:
+			if r.Values().Len() == 1 {
+				result = append(result, labelValue{r.Key(), r.Values().List()[0]})
+			}
+		}
+	}
+	return result
+}
+
+// add indexes a matcher by the equality requirement with
+// the least number of already indexed matchers.
+func (this *matcherIndex) add(m *BootProfileMatcher) {
+	key := m.Key()
+	var found *labelValue
+	size := 0
+	for _, lv := range equalities(m.selector) {
+		n := len(this.labels[lv.label][lv.value])
+		if found == nil || n < size {
+			lv := lv
+			found, size = &lv, n
+		}
+	}
+	if found == nil {
+		this.other[key] = m
+		return
+	}
+	values := this.labels[found.label]
+	if values == nil {
+		values = map[string]map[string]*BootProfileMatcher{}
+		this.labels[found.label] = values
+	}
+	set := values[found.value]
+	if set == nil {
+		set = map[string]*BootProfileMatcher{}
+		values[found.value] = set
+	}
+	set[key] = m
+	this.keys[key] = *found
+}
+
+func (this *matcherIndex) copy() *matcherIndex {
+	index := newMatcherIndex()
+	for label, values := range this.labels {
+		cvalues := make(map[string]map[string]*BootProfileMatcher, len(values))
+		for value, set := range values {
+			cset := make(map[string]*BootProfileMatcher, len(set))
+			for k, m := range set {
+				cset[k] = m
+			}
+			cvalues[value] = cset
+		}
+		index.labels[label] = cvalues
+	}
+	for k, m := range this.other {
+		index.other[k] = m
+	}
+	for k, lv := range this.keys {
+		index.keys[k] = lv
+	}
+	return index
+}
+
+func (this *matcherIndex) remove(m *BootProfileMatcher) {
+	key := m.Key()
+	lv, ok := this.keys[key]
+	if !ok {
+		delete(this.other, key)
+		return
+	}
+	delete(this.keys, key)
+	values := this.labels[lv.label]
+	delete(values[lv.value], key)
+	if len(values[lv.value]) == 0 {
+		delete(values, lv.value)
+		if len(values) == 0 {
+			delete(this.labels, lv.label)
+		}
+	}
+}
+
+// candidates calls the given function for all matchers
+// possibly matching the given metadata.
+func (this *matcherIndex) candidates(meta MetaData, f func(m *BootProfileMatcher)) {
+	for _, m := range this.other {
+		f(m)
+	}
+	for label, values := range this.labels {
+		if !meta.Has(label) {
+			continue
+		}
+		for _, m := range values[meta.Get(label)] {
+			f(m)
+		}
+	}
+}
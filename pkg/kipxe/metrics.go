@@ -0,0 +1,184 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kipxe
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/mandelsoft/kipxe/pkg/metrics"
+)
+
+// MAX_NAME_LABELS limits the number of distinct object names
+// used as metric labels.
+const MAX_NAME_LABELS = 100
+
+var (
+	requestsTotal = metrics.NewCounterVec("kipxe_requests_total",
+		"Number of HTTP resource requests by status code.", "code")
+	requestDuration = metrics.NewHistogramVec("kipxe_request_duration_seconds",
+		"Duration of HTTP resource requests by status code.", nil, "code")
+
+	matcherMatches = metrics.NewCounterVec("kipxe_matcher_matches_total",
+		"Number of requests resolved by a matcher.", "matcher")
+	profileMatches = metrics.NewCounterVec("kipxe_profile_matches_total",
+		"Number of requests resolved by a profile.", "profile")
+	resourceMatches = metrics.NewCounterVec("kipxe_resource_matches_total",
+		"Number of requests resolved to a resource.", "resource")
+
+	metadataMappingDuration = metrics.NewHistogramVec("kipxe_metadata_mapping_duration_seconds",
+		"Duration of the request metadata mapping.", nil)
+	spiffMappingDuration = metrics.NewHistogramVec("kipxe_spiff_mapping_duration_seconds",
+		"Duration of the spiff mapping stages.", nil, "stage")
+	processingDuration = metrics.NewHistogramVec("kipxe_processing_duration_seconds",
+		"Duration of the resource content processing.", nil)
+
+	urlMapperDuration = metrics.NewHistogramVec("kipxe_url_mapper_duration_seconds",
+		"Duration of URL metadata mapper calls.", nil, "host")
+	urlMapperErrors = metrics.NewCounterVec("kipxe_url_mapper_errors_total",
+		"Number of failed URL metadata mapper calls.", "host")
+
+	cacheHits = metrics.NewCounterVec("kipxe_cache_hits_total",
+		"Number of URL cache hits.")
+	cacheMisses = metrics.NewCounterVec("kipxe_cache_misses_total",
+		"Number of URL cache misses.")
+	cacheFillBytes = metrics.NewCounterVec("kipxe_cache_fill_bytes_total",
+		"Number of bytes written to the URL cache.")
+	cacheFillErrors = metrics.NewCounterVec("kipxe_cache_fill_errors_total",
+		"Number of failed URL cache fills.")
+	cacheRevalidations = metrics.NewCounterVec("kipxe_cache_revalidations_total",
+		"Number of URL cache revalidations by result.", "result")
+
+	renderCacheHits = metrics.NewCounterVec("kipxe_render_cache_hits_total",
+		"Number of requests served from rendered documents.")
+	renderCacheMisses = metrics.NewCounterVec("kipxe_render_cache_misses_total",
+		"Number of requests requiring the processing of a document.")
+
+	matcherNames  = metrics.NewLabelGuard(MAX_NAME_LABELS)
+	profileNames  = metrics.NewLabelGuard(MAX_NAME_LABELS)
+	resourceNames = metrics.NewLabelGuard(MAX_NAME_LABELS)
+	mapperHosts   = metrics.NewLabelGuard(MAX_NAME_LABELS)
+)
+
+func init() {
+	metrics.MustRegister(
+		requestsTotal, requestDuration,
+		matcherMatches, profileMatches, resourceMatches,
+		metadataMappingDuration, spiffMappingDuration, processingDuration,
+		urlMapperDuration, urlMapperErrors,
+		cacheHits, cacheMisses, cacheFillBytes, cacheFillErrors, cacheRevalidations,
+		renderCacheHits, renderCacheMisses,
+		caches,
+	)
+}
+
+func countMatch(matcher, profile, resource Name) {
+	matcherMatches.Inc(matcherNames.Value(matcher.String()))
+	profileMatches.Inc(profileNames.Value(profile.String()))
+	resourceMatches.Inc(resourceNames.Value(resource.String()))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+var (
+	cacheSizeDesc = prometheus.NewDesc("kipxe_cache_size_bytes",
+		"Size of the content stored in a URL cache.", []string{"cache"}, nil)
+	cacheReservedDesc = prometheus.NewDesc("kipxe_cache_reserved_bytes",
+		"Space reserved for ongoing URL cache fills.", []string{"cache"}, nil)
+	cacheActiveDesc = prometheus.NewDesc("kipxe_cache_active_entries",
+		"Number of URL cache entries currently in use.", []string{"cache"}, nil)
+
+	caches = &cacheCollector{}
+)
+
+// cacheCollector reports the state of all directory caches as gauges.
+type cacheCollector struct {
+	lock   sync.Mutex
+	caches []*DirCache
+}
+
+var _ prometheus.Collector = &cacheCollector{}
+
+func (this *cacheCollector) add(cache *DirCache) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.caches = append(this.caches, cache)
+}
+
+func (this *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheSizeDesc
+	ch <- cacheReservedDesc
+	ch <- cacheActiveDesc
+}
+
+func (this *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, c := range this.caches {
+		c.lock.Lock()
+		active := len(c.actions)
+		c.lock.Unlock()
+		gauge(ch, cacheSizeDesc, atomic.LoadInt64(&c.size), c.path)
+		gauge(ch, cacheReservedDesc, atomic.LoadInt64(&c.pending), c.path)
+		gauge(ch, cacheActiveDesc, int64(active), c.path)
+	}
+}
+
+func gauge(ch chan<- prometheus.Metric, desc *prometheus.Desc, v int64, labels ...string) {
+	if m, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, float64(v), labels...); err == nil {
+		ch <- m
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// statusRecorder keeps track of the status code written for a request.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (this *statusRecorder) WriteHeader(status int) {
+	if this.status == 0 {
+		this.status = status
+	}
+	this.ResponseWriter.WriteHeader(status)
+}
+
+func (this *statusRecorder) Write(data []byte) (int, error) {
+	if this.status == 0 {
+		this.status = http.StatusOK
+	}
+	return this.ResponseWriter.Write(data)
+}
+
+func (this *statusRecorder) observe(start time.Time) {
+	status := this.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	code := strconv.Itoa(status)
+	requestsTotal.Inc(code)
+	requestDuration.ObserveDuration(start, code)
+}
@@ -19,11 +19,15 @@
 package kipxe
 
 import (
+	"fmt"
 	"strings"
 	"text/template"
 
 	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/gardener/controller-manager-library/pkg/types"
 	"github.com/gardener/controller-manager-library/pkg/types/infodata/simple"
+	"github.com/mandelsoft/spiff/spiffing"
+	spiffyaml "github.com/mandelsoft/spiff/yaml"
 	"gopkg.in/yaml.v2"
 )
 
@@ -45,39 +49,23 @@ func MimeType(mime string) string {
 
 func Process(name string, values simple.Values, src Source) (Source, error) {
 	var data []byte
-	var err error
 	mime := MimeType(src.MimeType())
 	switch mime {
-	case MIME_JSON:
+	case MIME_JSON, MIME_YAML:
 		in, err := src.Bytes()
 		if err != nil {
 			return nil, err
 		}
 		if in == nil {
-			data, err = MarshalJSON(values)
+			data, err = marshal(mime, values)
 		} else {
-			return src, nil
+			data, err = ProcessSpiff(name, mime, values, in)
 		}
-	case MIME_YAML:
-		in, err := src.Bytes()
 		if err != nil {
 			return nil, err
 		}
-		if in == nil {
-			data, err = yaml.Marshal(values)
-		} else {
-			return src, nil
-		}
 	case MIME_TEXT, MIME_GTEXT, MIME_SHELL, MIME_XML, MIME_CACERT, MIME_PEM:
-		b, err := src.Bytes()
-		if err != nil {
-			return nil, err
-		}
-		if b == nil {
-			b = []byte{}
-		}
-		logger.Infof("go template (len %d) with %s\n", len(b), values)
-		t, err := template.New(name).Option("missingkey=error").Parse(string(b))
+		t, err := processingTemplate(name, values, src)
 		if err != nil {
 			return nil, err
 		}
@@ -90,8 +78,63 @@ func Process(name string, values simple.Values, src Source) (Source, error) {
 	default:
 		return src, nil
 	}
+	return NewFilteredSource(src, data), nil
+}
+
+// processingTemplate provides the go template for the content of a source.
+// Templates of static sources are parsed in advance, the templates for
+// other content are cached by their content hash.
+func processingTemplate(name string, values simple.Values, src Source) (*template.Template, error) {
+	b, err := src.Bytes()
 	if err != nil {
 		return nil, err
 	}
-	return NewFilteredSource(src, data), nil
+	logger.Infof("go template (len %d) with %s\n", len(b), values)
+	if ts, ok := src.(TemplateSource); ok && ts.Template() != nil {
+		return ts.Template(), nil
+	}
+	if b == nil {
+		b = []byte{}
+	}
+	return templates.Get(name, b)
+}
+
+// ProcessSpiff uses the given json or yaml document as spiff template
+// and merges it with the processing values as stub. The result is
+// serialized again according to the given mime type.
+// The top level fields of the processing values are implicitly
+// available as temporary fields in the template.
+func ProcessSpiff(name string, mime string, values simple.Values, in []byte) ([]byte, error) {
+	ctx := spiffing.New().WithMode(0)
+	templ, err := ctx.Unmarshal(name, in)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid spiff template: %s", name, err)
+	}
+	if vm, ok := templ.Value().(map[string]spiffing.Node); ok {
+		for k := range values {
+			if vm[k] == nil {
+				vm[k] = spiffyaml.NewNode("(( &temporary ))", "values")
+			}
+		}
+	}
+	stub, err := spiffing.ToNode(fmt.Sprintf("%s:values", name), map[string]interface{}(types.NormValues(values)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid values: %s", name, err)
+	}
+	result, err := ctx.Cascade(templ, []spiffing.Node{stub})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	out, err := ctx.Normalize(result)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return marshal(mime, out)
+}
+
+func marshal(mime string, values interface{}) ([]byte, error) {
+	if mime == MIME_YAML {
+		return yaml.Marshal(values)
+	}
+	return MarshalJSON(values)
 }
@@ -23,6 +23,7 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/gardener/controller-manager-library/pkg/logger"
 	"github.com/gardener/controller-manager-library/pkg/types/infodata/simple"
@@ -33,6 +34,7 @@ type BootProfiles struct {
 	elements map[string]*BootProfile
 	nested   *BootResources
 	users    map[string]NameSet
+	version  uint64
 }
 
 func NewProfiles(nested *BootResources) *BootProfiles {
@@ -43,6 +45,20 @@ func NewProfiles(nested *BootResources) *BootProfiles {
 	}
 }
 
+// snapshot provides an immutable copy of the profile set
+// based on the given resource set.
+// The caller must hold the read lock.
+func (this *BootProfiles) snapshot(nested *BootResources) *BootProfiles {
+	elements := make(map[string]*BootProfile, len(this.elements))
+	for k, e := range this.elements {
+		elements[k] = e
+	}
+	return &BootProfiles{
+		elements: elements,
+		nested:   nested,
+	}
+}
+
 func (this *BootProfiles) Recheck(set NameSet) NameSet {
 	this.lock.RLock()
 	defer this.lock.RUnlock()
@@ -80,6 +96,7 @@ func (this *BootProfiles) Set(m *BootProfile) (NameSet, error) {
 	defer this.lock.Unlock()
 
 	key := m.Key()
+	atomic.AddUint64(&this.version, 1)
 	add := m.Documents()
 	logger.Infof("documents for profile %s: %s", key, add)
 	old := this.elements[key]
@@ -108,6 +125,7 @@ func (this *BootProfiles) Delete(name Name) NameSet {
 	key := name.String()
 	old := this.elements[key]
 	if old != nil {
+		atomic.AddUint64(&this.version, 1)
 		delete(this.elements, key)
 	}
 	users := this.users[key]
@@ -207,8 +225,11 @@ func NewProfile(name Name, mapping Mapping, values simple.Values, deliverables .
 			if d.pattern != nil {
 				return nil, fmt.Errorf("entry %d: both, path and pattern specified", i)
 			}
-			if old := m[d.path]; old != nil {
-				return nil, fmt.Errorf("duplicate deliverable for path %s (%s and %s)", old.name, d.name)
+			if r := ReservedPath(d.path); r != "" {
+				return nil, fmt.Errorf("entry %d: path %s is reserved for the %s endpoint", i, d.path, r)
+			}
+			if old := paths[d.path]; old != nil {
+				return nil, fmt.Errorf("duplicate deliverable for path %s (%s and %s)", d.path, old.name, d.name)
 			}
 			paths[d.path] = d
 		}
@@ -0,0 +1,147 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kipxe
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+
+	"github.com/gardener/controller-manager-library/pkg/types/infodata/simple"
+)
+
+// renderKey identifies a rendered document by the resource, its
+// generation and the hash of the final processing values.
+type renderKey struct {
+	resource   string
+	generation uint64
+	values     [sha256.Size]byte
+}
+
+type renderEntry struct {
+	key    renderKey
+	source Source
+	size   int64
+}
+
+// RenderCache memoizes the rendered content of resources with static
+// content (text, binary and metadata documents). The content of such
+// resources only depends on the resource and the processing values,
+// so identical requests can skip the processing. The cache is limited
+// by the size of the rendered content, the least recently used
+// documents are discarded first.
+type RenderCache struct {
+	lock    sync.Mutex
+	maxSize int64
+	size    int64
+	entries map[renderKey]*list.Element
+	lru     *list.List
+}
+
+func NewRenderCache(maxSize int64) *RenderCache {
+	return &RenderCache{
+		maxSize: maxSize,
+		entries: map[renderKey]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// Size provides the size of the cached content and the number of entries.
+func (this *RenderCache) Size() (int64, int) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.size, this.lru.Len()
+}
+
+func (this *RenderCache) key(doc *BootResource, values simple.Values) (renderKey, bool) {
+	if !staticSource(doc.GetSource()) {
+		return renderKey{}, false
+	}
+	data, err := MarshalJSON(values)
+	if err != nil {
+		return renderKey{}, false
+	}
+	return renderKey{doc.Key(), doc.generation, sha256.Sum256(data)}, true
+}
+
+func (this *RenderCache) get(key renderKey) Source {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	e := this.entries[key]
+	if e == nil {
+		renderCacheMisses.Inc()
+		return nil
+	}
+	renderCacheHits.Inc()
+	this.lru.MoveToFront(e)
+	return e.Value.(*renderEntry).source
+}
+
+func (this *RenderCache) set(key renderKey, src Source) {
+	data, err := src.Bytes()
+	if err != nil {
+		return
+	}
+	size := int64(len(data))
+	if size > this.maxSize {
+		return
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.entries[key] != nil {
+		return
+	}
+	this.entries[key] = this.lru.PushFront(&renderEntry{key, src, size})
+	this.size += size
+	for this.size > this.maxSize {
+		this.remove(this.lru.Back())
+	}
+}
+
+func (this *RenderCache) remove(e *list.Element) {
+	entry := e.Value.(*renderEntry)
+	delete(this.entries, entry.key)
+	this.lru.Remove(e)
+	this.size -= entry.size
+}
+
+// Invalidate discards all rendered documents for a resource.
+func (this *RenderCache) Invalidate(name Name) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	key := name.String()
+	for e := this.lru.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*renderEntry).key.resource == key {
+			this.remove(e)
+		}
+		e = next
+	}
+}
+
+// staticSource checks whether the content of a source is completely
+// determined by the resource itself.
+func staticSource(src Source) bool {
+	switch src.(type) {
+	case *DataSource, *textSource, *metaDataSource:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kipxe
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/convert"
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/gardener/controller-manager-library/pkg/types"
+	"github.com/gardener/controller-manager-library/pkg/types/infodata/simple"
+)
+
+// NewRequestMetaData provides the initial metadata for a request
+// for a resource path issued by a dedicated origin.
+// The additional value sets (for example query parameters or header fields)
+// are added according to the metadata rules.
+func NewRequestMetaData(path, origin string, values ...map[string][]string) MetaData {
+	metadata := MetaData{}
+	metadata["RESOURCE_PATH"] = path
+	metadata["ORIGIN"] = origin
+	for _, v := range values {
+		fill(metadata, v)
+	}
+	return metadata
+}
+
+// GetSource executes the complete matching pipeline for a resource path
+// and the initial request metadata. The request is optional and
+// is only passed to the metadata mappers.
+func (this *InfoBase) GetSource(logger logger.LogContext, metadata MetaData, path string, req *http.Request) (Source, error) {
+	return this.Snapshot().GetSource(logger, metadata, path, req)
+}
+
+// GetSource executes the complete matching pipeline against the snapshot.
+func (this *Snapshot) GetSource(logger logger.LogContext, metadata MetaData, path string, req *http.Request) (Source, error) {
+	return this.resolve(logger, metadata, path, req, nil)
+}
+
+// Explain executes the matching pipeline like GetSource, but
+// provides a trace of all intermediate steps instead of the content.
+func (this *InfoBase) Explain(logger logger.LogContext, metadata MetaData, path string, req *http.Request) *Explanation {
+	return this.Snapshot().Explain(logger, metadata, path, req)
+}
+
+// Explain explains the matching pipeline for the snapshot.
+func (this *Snapshot) Explain(logger logger.LogContext, metadata MetaData, path string, req *http.Request) *Explanation {
+	explain := NewExplanation(path, metadata)
+	explain.Version = this.version
+	source, err := this.resolve(logger, metadata, path, req, explain)
+	explain.done(source, err)
+	return explain
+}
+
+func (this *Snapshot) resolve(logger logger.LogContext, metadata MetaData, path string, req *http.Request, explain *Explanation) (Source, error) {
+	var err error
+
+	if this.registry != nil {
+		start := time.Now()
+		if explain != nil {
+			metadata, err = this.registry.MapTraced(logger, metadata, req, explain.mapped)
+		} else {
+			metadata, err = this.registry.Map(logger, metadata, req)
+		}
+		metadataMappingDuration.ObserveDuration(start)
+		if err != nil {
+			return nil, NewStatusError(http.StatusBadRequest, "cannot map metadata: %s", err)
+		}
+		if s := convert.BestEffortString(metadata[REQUEST_REJECT]); s != "" {
+			return nil, NewStatusError(http.StatusNotAcceptable, "%s", s)
+		}
+	}
+
+	logger.Infof("matching %s", metadata)
+	var list BootProfileMatcherSlice
+	if explain != nil {
+		list, explain.Matchers = this.matchers.MatchTraced(logger, metadata)
+	} else {
+		list = this.matchers.Match(logger, metadata)
+	}
+	if len(list) == 0 {
+		logger.Infof("no matcher found")
+		return nil, NewStatusError(http.StatusNotFound, "no matching matcher")
+	}
+
+	logger.Infof("found %d matchers: %s", len(list), MatcherNameList(list))
+
+	for _, matcher := range list {
+		pname := matcher.ProfileName()
+		logger.Infof("looking in matcher %s -> profile %s", matcher.Key(), pname)
+		profile := this.profiles.Get(pname)
+		if profile == nil {
+			explain.profile(matcher, pname, false)
+			return nil, NewStatusError(http.StatusNotFound, "profile %q not found", pname)
+		}
+
+		deliverable, list := profile.GetDeliverableForPath(path)
+		explain.profile(matcher, pname, deliverable != nil)
+		if deliverable == nil {
+			continue
+		}
+		explain.deliverable(pname, deliverable, list)
+
+		doc := this.resources.Get(deliverable.Name())
+		if doc == nil {
+			return nil, NewStatusError(http.StatusNotFound, "document %q for profile %q resource %q not found", deliverable.Name(), pname, path)
+		}
+
+		logger.Infof("found document %s in profile %s", deliverable.Name(), pname)
+		if explain == nil {
+			countMatch(matcher.Name(), pname, deliverable.Name())
+		}
+
+		source := doc.GetSource()
+
+		if mappedsource, _ := source.(SourceMapper); !doc.skipProcessing || mappedsource != nil {
+			match_info := map[string]interface{}{}
+			resmatch := types.CopyAndNormalize(list)
+			if resmatch != nil {
+				match_info["resource"] = resmatch
+			}
+			match_info["document"] = deliverable.Name().String()
+			match_info["profile"] = pname.String()
+			match_info["matcher"] = matcher.Name().String()
+
+			metavalues := simple.Values{}
+			metadata["match-info"] = match_info
+			metavalues["<<<"] = "(( merge ))"
+			metavalues["metadata"] = metadata
+			intermediate := NewSimpleIntermediateValues(types.NormValues(simple.Values(metadata).DeepCopy()))
+			start := time.Now()
+			intermediate, err = mapit(fmt.Sprintf("matcher %s", matcher.Name()), matcher.GetMapping(), matcher.GetValues(), metavalues, intermediate)
+			spiffMappingDuration.ObserveDuration(start, "matcher")
+			explain.stage("matcher", matcher.Name(), intermediate, err)
+			if err != nil {
+				return nil, NewStatusError(http.StatusUnprocessableEntity, "%s", err)
+			}
+			start = time.Now()
+			intermediate, err = mapit(fmt.Sprintf("profile %s", pname), profile.GetMapping(), profile.GetValues(), metavalues, intermediate)
+			spiffMappingDuration.ObserveDuration(start, "profile")
+			explain.stage("profile", pname, intermediate, err)
+			if err != nil {
+				return nil, NewStatusError(http.StatusUnprocessableEntity, "%s", err)
+			}
+			start = time.Now()
+			intermediate, err = mapit(fmt.Sprintf("profile %s, document %s", pname, deliverable.Name()), doc.GetMapping(), doc.GetValues(), metavalues, intermediate)
+			spiffMappingDuration.ObserveDuration(start, "document")
+			explain.stage("document", deliverable.Name(), intermediate, err)
+			if err != nil {
+				return nil, NewStatusError(http.StatusUnprocessableEntity, "%s", err)
+			}
+
+			v, err := intermediate.Values()
+			if err != nil {
+				return nil, NewStatusError(http.StatusUnprocessableEntity, "%s", err)
+			}
+
+			var rkey renderKey
+			memoize := false
+			rendered := this.resources.RenderCache()
+			if rendered != nil && explain == nil {
+				rkey, memoize = rendered.key(doc, v)
+				if memoize {
+					if cached := rendered.get(rkey); cached != nil {
+						logger.Infof("using rendered document %s", deliverable.Name())
+						return cached, nil
+					}
+				}
+			}
+
+			if mappedsource != nil {
+				source, err = mappedsource.Map(v)
+				if err != nil {
+					return nil, NewStatusError(http.StatusUnprocessableEntity, "%s", err)
+				}
+			}
+
+			if !doc.skipProcessing {
+				start = time.Now()
+				source, err = Process("document", v, source)
+				processingDuration.ObserveDuration(start)
+				if err != nil {
+					return nil, NewStatusError(http.StatusUnprocessableEntity, "%s", err)
+				}
+			}
+			if memoize {
+				rendered.set(rkey, source)
+			}
+		}
+		return source, nil
+	}
+	return nil, NewStatusError(http.StatusNotFound, "no resource %q found in matches", path)
+}
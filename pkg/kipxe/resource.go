@@ -20,14 +20,18 @@ package kipxe
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"github.com/gardener/controller-manager-library/pkg/types/infodata/simple"
 )
 
 type BootResources struct {
-	lock     sync.RWMutex
-	elements map[string]*BootResource
-	users    map[string]NameSet
+	lock       sync.RWMutex
+	elements   map[string]*BootResource
+	users      map[string]NameSet
+	generation uint64
+	rendered   *RenderCache
+	version    uint64
 }
 
 func NewResources() *BootResources {
@@ -37,6 +41,34 @@ func NewResources() *BootResources {
 	}
 }
 
+// SetRenderCache sets the cache used to memoize rendered documents.
+// It is invalidated whenever a resource is replaced or deleted.
+func (this *BootResources) SetRenderCache(cache *RenderCache) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.rendered = cache
+	atomic.AddUint64(&this.version, 1)
+}
+
+func (this *BootResources) RenderCache() *RenderCache {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.rendered
+}
+
+// snapshot provides an immutable copy of the resource set.
+// The caller must hold the read lock.
+func (this *BootResources) snapshot() *BootResources {
+	elements := make(map[string]*BootResource, len(this.elements))
+	for k, e := range this.elements {
+		elements[k] = e
+	}
+	return &BootResources{
+		elements: elements,
+		rendered: this.rendered,
+	}
+}
+
 func (this *BootResources) Recheck(set NameSet) NameSet {
 	this.lock.RLock()
 	defer this.lock.RUnlock()
@@ -65,6 +97,12 @@ func (this *BootResources) Set(m *BootResource) NameSet {
 	defer this.lock.Unlock()
 
 	key := m.Key()
+	atomic.AddUint64(&this.version, 1)
+	this.generation++
+	m.generation = this.generation
+	if old := this.elements[key]; old != nil && this.rendered != nil {
+		this.rendered.Invalidate(old.Name())
+	}
 	this.elements[key] = m
 	m.error = this.check(m)
 
@@ -82,7 +120,11 @@ func (this *BootResources) Delete(name Name) NameSet {
 	key := name.String()
 	old := this.elements[key]
 	if old != nil {
+		atomic.AddUint64(&this.version, 1)
 		delete(this.elements, key)
+		if this.rendered != nil {
+			this.rendered.Invalidate(name)
+		}
 	}
 	users := this.users[key]
 	if users == nil {
@@ -156,6 +198,7 @@ type BootResource struct {
 	error          error
 	source         Source
 	skipProcessing bool
+	generation     uint64
 }
 
 func NewResource(name Name, mapping Mapping, values simple.Values, src Source, skipProcessing bool) *BootResource {
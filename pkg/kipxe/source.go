@@ -20,7 +20,9 @@ package kipxe
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -28,6 +30,7 @@ import (
 	"net/url"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/emicklei/go-restful"
 	"github.com/gardener/controller-manager-library/pkg/logger"
@@ -90,12 +93,24 @@ func (this *SourceSupport) IwriteErrorResponse(w http.ResponseWriter, statusCode
 	}
 }
 
+// Iserve serves generated content. It is tagged with an ETag derived
+// from the content hash (if not already set), and conditional
+// and range requests are handled.
 func (this *SourceSupport) Iserve(data []byte, w http.ResponseWriter, r *http.Request) {
 	mime := this.MimeType()
 	if mime != "" {
-		w.Header().Add(CONTENT_TYPE, mime)
+		w.Header().Set(CONTENT_TYPE, mime)
+	}
+	if w.Header().Get(CONTENT_ETAG) == "" {
+		w.Header().Set(CONTENT_ETAG, ContentETag(data))
 	}
-	w.Write(data)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// ContentETag provides a strong entity tag for some content.
+func ContentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "\"" + hex.EncodeToString(sum[:16]) + "\""
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -235,17 +250,50 @@ func (this *urlSource) Bytes() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// request headers forwarded to the origin for proxied content
+var forwardedRequestHeaders = []string{
+	"Range",
+	"If-Range",
+	"If-None-Match",
+	"If-Modified-Since",
+}
+
+// response headers of the origin propagated for proxied content
+var forwardedResponseHeaders = []string{
+	"Content-Length",
+	"Content-Range",
+	"Accept-Ranges",
+	CONTENT_ETAG,
+	CONTENT_LAST_MODIFIED,
+}
+
 func (this *urlSource) Serve(w http.ResponseWriter, r *http.Request) {
 	if this.cache != nil {
 		this.cache.Serve(this.url, w, r)
 		return
 	}
-	mime := this.MimeType()
-	resp, err := http.Get(this.url.String())
+	method := http.MethodGet
+	if r.Method == http.MethodHead {
+		method = http.MethodHead
+	}
+	req, err := http.NewRequestWithContext(r.Context(), method, this.url.String(), nil)
+	if err != nil {
+		this.IwriteErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	for _, h := range forwardedRequestHeaders {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		this.IwriteErrorResponse(w, http.StatusUnprocessableEntity, err)
 		return
 	}
+	defer resp.Body.Close()
+
+	mime := this.MimeType()
 	t := resp.Header.Get(CONTENT_TYPE)
 	if t != "" {
 		mime = t
@@ -253,24 +301,29 @@ func (this *urlSource) Serve(w http.ResponseWriter, r *http.Request) {
 	if mime != "" {
 		w.Header().Add(CONTENT_TYPE, mime)
 	}
-	defer resp.Body.Close()
-	var tmp [8196]byte
+	for _, h := range forwardedResponseHeaders {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	if method == http.MethodHead {
+		return
+	}
 
+	var tmp [8196]byte
 	for {
 		n, err := resp.Body.Read(tmp[:])
 		if n > 0 {
-			w.Write(tmp[:n])
+			if _, err := w.Write(tmp[:n]); err != nil {
+				return
+			}
 		}
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			w.WriteHeader(http.StatusInternalServerError)
+			// an incomplete body is detected by the client
+			// by the propagated content length
 			return
 		}
-		if n < 0 {
-			break
-		}
 	}
 }
 
@@ -293,7 +346,7 @@ func NewMappedURLSource(mime string, rawURL string, cache Cache) (URLSource, err
 	if templ == nil {
 		u, err := url.Parse(rawURL)
 		if err != nil {
-			return nil, fmt.Errorf("invalid url %q: ", rawURL, err)
+			return nil, fmt.Errorf("invalid url %q: %s", rawURL, err)
 		}
 		return NewURLSource(mime, u, cache), nil
 	}
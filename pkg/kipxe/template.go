@@ -0,0 +1,128 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kipxe
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"text/template"
+)
+
+// TemplateSource is implemented by sources providing a go template
+// parsed in advance for their content.
+type TemplateSource interface {
+	Source
+	Template() *template.Template
+}
+
+// ParseTemplate parses a go template used to process textual content.
+func ParseTemplate(name string, txt string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(txt)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+const templateCacheSize = 256
+
+type templateKey struct {
+	name string
+	hash [sha256.Size]byte
+}
+
+type templateEntry struct {
+	key   templateKey
+	templ *template.Template
+}
+
+// templateCache keeps parsed go templates for the content of dynamic
+// sources (config maps, secrets or URLs) by their content hash.
+// The least recently used templates are discarded if the cache is full.
+type templateCache struct {
+	lock    sync.Mutex
+	max     int
+	entries map[templateKey]*list.Element
+	lru     *list.List
+}
+
+var templates = newTemplateCache(templateCacheSize)
+
+func newTemplateCache(max int) *templateCache {
+	return &templateCache{
+		max:     max,
+		entries: map[templateKey]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+func (this *templateCache) Get(name string, data []byte) (*template.Template, error) {
+	key := templateKey{name, sha256.Sum256(data)}
+	this.lock.Lock()
+	if e := this.entries[key]; e != nil {
+		this.lru.MoveToFront(e)
+		this.lock.Unlock()
+		return e.Value.(*templateEntry).templ, nil
+	}
+	this.lock.Unlock()
+
+	templ, err := ParseTemplate(name, string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if e := this.entries[key]; e != nil {
+		this.lru.MoveToFront(e)
+		return e.Value.(*templateEntry).templ, nil
+	}
+	this.entries[key] = this.lru.PushFront(&templateEntry{key, templ})
+	for this.lru.Len() > this.max {
+		e := this.lru.Back()
+		delete(this.entries, e.Value.(*templateEntry).key)
+		this.lru.Remove(e)
+	}
+	return templ, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type textSource struct {
+	DataSource
+	templ *template.Template
+}
+
+var _ TemplateSource = &textSource{}
+
+// NewTemplateSource provides a source for static text, whose go template
+// is parsed once.
+func NewTemplateSource(name, mime, text string) (Source, error) {
+	templ, err := ParseTemplate(name, text)
+	if err != nil {
+		return nil, err
+	}
+	return &textSource{
+		DataSource: NewNestedDataSource(mime, []byte(text)),
+		templ:      templ,
+	}, nil
+}
+
+func (this *textSource) Template() *template.Template {
+	return this.templ
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package kipxe
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gardener/controller-manager-library/pkg/logger"
+
+	"github.com/mandelsoft/kipxe/pkg/tftp"
+)
+
+// TFTPHandler serves TFTP requests by the matching engine.
+// The requested file name is used as resource path and the
+// address of the requester as origin. Query parameters
+// appended to the file name are used as metadata.
+type TFTPHandler struct {
+	infobase *InfoBase
+}
+
+var _ tftp.Handler = &TFTPHandler{}
+
+func NewTFTPHandler(infobase *InfoBase) tftp.Handler {
+	return &TFTPHandler{infobase: infobase}
+}
+
+func (this *TFTPHandler) ReadFile(logger logger.LogContext, filename string, remote *net.UDPAddr) ([]byte, error) {
+	path := strings.TrimPrefix(filename, "/")
+	query := url.Values{}
+	if i := strings.Index(path, "?"); i >= 0 {
+		query, _ = url.ParseQuery(path[i+1:])
+		path = path[:i]
+	}
+	metadata := NewRequestMetaData(path, remote.IP.String(), query)
+	logger.Infof("request %s: %s", path, metadata)
+
+	source, err := this.infobase.GetSource(logger, metadata, path, nil)
+	if err != nil {
+		switch StatusCode(err) {
+		case http.StatusNotFound:
+			return nil, tftp.NewError(tftp.ERR_NOT_FOUND, "%s", err)
+		case http.StatusNotAcceptable:
+			return nil, tftp.NewError(tftp.ERR_ACCESS, "%s", err)
+		}
+		return nil, err
+	}
+	data, err := source.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, tftp.NewError(tftp.ERR_NOT_FOUND, "no content for %q", path)
+	}
+	return data, nil
+}
@@ -0,0 +1,260 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package machines
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/convert"
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/gardener/controller-manager-library/pkg/resources"
+	"github.com/gardener/controller-manager-library/pkg/types"
+	"github.com/gardener/controller-manager-library/pkg/types/infodata/simple"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/flowcontrol"
+
+	"github.com/mandelsoft/kipxe/pkg/apis/ipxe/v1alpha1"
+	"github.com/mandelsoft/kipxe/pkg/kipxe"
+)
+
+// DISCOVERY_WEIGHT is the weight of the discovery mapper. Mappers are
+// executed in descending weight order, so it uses the lowest possible
+// weight to be executed after all mappers identifying known machines.
+const DISCOVERY_WEIGHT = math.MinInt32
+
+// DISCOVERY_RETENTION is the period a discovered machine is not
+// registered again.
+const DISCOVERY_RETENTION = 10 * time.Minute
+
+// DISCOVERED_PURPOSE is the MAC purpose used for discovered MAC addresses.
+const DISCOVERED_PURPOSE = "discovered"
+
+// discoveredFields are the request metadata fields taken into the
+// values of a discovered machine.
+var discoveredFields = []string{"serial", "manufacturer", "platform"}
+
+// Discovery is a metadata mapper registering Machine resources for
+// requests of unknown machines. Registrations are rate limited and
+// de-duplicated, the request itself is not modified.
+type Discovery struct {
+	index     *Index
+	resource  resources.Interface
+	namespace string
+	limiter   flowcontrol.RateLimiter
+
+	lock    sync.Mutex
+	pending map[string]time.Time
+}
+
+var _ kipxe.DryRunMetaDataMapper = &Discovery{}
+
+// NewDiscovery creates a discovery registering machines in the given
+// namespace with at most rate registrations per minute.
+func NewDiscovery(index *Index, resource resources.Interface, namespace string, rate int) *Discovery {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &Discovery{
+		index:     index,
+		resource:  resource,
+		namespace: namespace,
+		limiter:   flowcontrol.NewTokenBucketRateLimiter(float32(rate)/60, burst),
+		pending:   map[string]time.Time{},
+	}
+}
+
+func (this *Discovery) Weight() int {
+	return DISCOVERY_WEIGHT
+}
+
+func (this *Discovery) String() string {
+	return "machine discovery"
+}
+
+func (this *Discovery) Map(logger logger.LogContext, values kipxe.MetaData, req *http.Request) (kipxe.MetaData, error) {
+	if convert.BestEffortBool(values[kipxe.MACHINE_FOUND]) || values[kipxe.REQUEST_REJECT] != nil {
+		return values, nil
+	}
+	m := this.machine(values)
+	if m == nil {
+		return values, nil
+	}
+	if !this.request(logger, m) {
+		return values, nil
+	}
+	logger.Infof("registering discovered machine %s/%s", m.Namespace, m.Name)
+	go this.register(logger, m)
+	return values, nil
+}
+
+// DryRun is used for traced mappings (the explain endpoint). Explain
+// requests may use arbitrary metadata, therefore they never register
+// machines. The note describes the omitted registration.
+func (this *Discovery) DryRun(logger logger.LogContext, values kipxe.MetaData, req *http.Request) (kipxe.MetaData, string, error) {
+	if convert.BestEffortBool(values[kipxe.MACHINE_FOUND]) || values[kipxe.REQUEST_REJECT] != nil {
+		return values, "", nil
+	}
+	m := this.machine(values)
+	if m == nil {
+		return values, "no registration: neither UUID nor valid MAC address given", nil
+	}
+	if this.known(m) {
+		return values, "no registration: machine already known", nil
+	}
+	this.lock.Lock()
+	pending := this._pending(identities(m), time.Now())
+	this.lock.Unlock()
+	if pending {
+		return values, fmt.Sprintf("no registration: registration of %s/%s pending", m.Namespace, m.Name), nil
+	}
+	return values, fmt.Sprintf("registration of discovered machine %s/%s skipped for explain request", m.Namespace, m.Name), nil
+}
+
+// machine creates the Machine resource for the request metadata.
+// Requests without UUID and valid MAC address cannot be registered.
+func (this *Discovery) machine(values kipxe.MetaData) *v1alpha1.Machine {
+	uuid := ""
+	if s, ok := values["uuid"].(string); ok {
+		uuid = NormalizeUUID(s)
+	}
+	var macs []string
+	list, ok := values["__mac__"].([]interface{})
+	if !ok {
+		list = []interface{}{values["mac"]}
+	}
+	for _, v := range list {
+		if s, ok := v.(string); ok {
+			if mac, err := NormalizeMAC(s); err == nil {
+				macs = append(macs, mac)
+			}
+		}
+	}
+
+	name := ""
+	switch {
+	case IsCanonicalUUID(uuid):
+		name = "discovered-" + uuid
+	case len(macs) > 0:
+		name = "discovered-" + strings.ReplaceAll(macs[0], ":", "-")
+	default:
+		return nil
+	}
+
+	m := &v1alpha1.Machine{}
+	m.Namespace = this.namespace
+	m.Name = name
+	m.Labels = map[string]string{v1alpha1.LABEL_DISCOVERED: "true"}
+	m.Spec.UUID = uuid
+	if len(macs) > 0 {
+		m.Spec.MACs = v1alpha1.MachineMACs{DISCOVERED_PURPOSE: macs}
+	}
+	data := simple.Values{}
+	for _, f := range discoveredFields {
+		if s, ok := values[f].(string); ok && s != "" {
+			data[f] = s
+		}
+	}
+	m.Spec.Values = types.Values{Values: data}
+	return m
+}
+
+// identities provides the keys identifying a discovered machine.
+// Requests of the same machine may provide different subsets of them.
+func identities(m *v1alpha1.Machine) []string {
+	var keys []string
+	if m.Spec.UUID != "" {
+		keys = append(keys, "uuid:"+m.Spec.UUID)
+	}
+	for _, mac := range m.Spec.MACs[DISCOVERED_PURPOSE] {
+		keys = append(keys, "mac:"+mac)
+	}
+	return keys
+}
+
+// known checks whether the UUID or any MAC address of a discovered
+// machine is already used by an indexed machine.
+func (this *Discovery) known(m *v1alpha1.Machine) bool {
+	if m.Spec.UUID != "" && this.index.GetByUUID(m.Spec.UUID) != nil {
+		return true
+	}
+	for _, mac := range m.Spec.MACs[DISCOVERED_PURPOSE] {
+		if this.index.GetByMAC(mac) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// request checks whether a registration is required and allowed.
+// Registrations are de-duplicated by the UUID and all MAC addresses.
+func (this *Discovery) request(logger logger.LogContext, m *v1alpha1.Machine) bool {
+	if this.known(m) {
+		return false
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	now := time.Now()
+	for n, t := range this.pending {
+		if now.Sub(t) > DISCOVERY_RETENTION {
+			delete(this.pending, n)
+		}
+	}
+	keys := identities(m)
+	if this._pending(keys, now) {
+		return false
+	}
+	if !this.limiter.TryAccept() {
+		logger.Warnf("registration of discovered machine %s rate limited", m.Name)
+		return false
+	}
+	for _, k := range keys {
+		this.pending[k] = now
+	}
+	return true
+}
+
+// _pending checks whether a registration for any of the given keys
+// is pending. It must be called with the lock held.
+func (this *Discovery) _pending(keys []string, now time.Time) bool {
+	for _, k := range keys {
+		if t, ok := this.pending[k]; ok && now.Sub(t) <= DISCOVERY_RETENTION {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *Discovery) register(logger logger.LogContext, m *v1alpha1.Machine) {
+	_, err := this.resource.Create(m)
+	if err != nil && !errors.IsAlreadyExists(err) {
+		logger.Errorf("cannot register discovered machine %s/%s: %s", m.Namespace, m.Name, err)
+		this.lock.Lock()
+		for _, k := range identities(m) {
+			delete(this.pending, k)
+		}
+		this.lock.Unlock()
+	}
+}
@@ -0,0 +1,247 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package machines
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gardener/controller-manager-library/pkg/resources"
+
+	"github.com/mandelsoft/kipxe/pkg/kipxe"
+)
+
+// Index indexes machines by their UUID and all their MAC addresses.
+// If several machines claim the same UUID or MAC address, the oldest
+// machine (by creation timestamp, then by name) owns it, the others are
+// in conflict. This way the owner does not depend on the reconcile order.
+type Index struct {
+	lock     sync.RWMutex
+	elements map[string]*Machine
+	byUUID   map[string][]resources.ObjectName
+	byMAC    map[string][]resources.ObjectName
+}
+
+func NewIndex() *Index {
+	return &Index{
+		elements: map[string]*Machine{},
+		byUUID:   map[string][]resources.ObjectName{},
+		byMAC:    map[string][]resources.ObjectName{},
+	}
+}
+
+func (this *Index) GetByName(name resources.ObjectName) *Machine {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.elements[name.String()]
+}
+
+func (this *Index) GetByUUID(uuid string) *Machine {
+	uuid = NormalizeUUID(uuid)
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	if m := this.owner(this.byUUID[uuid]); m != nil {
+		return m
+	}
+	return this.owner(this.byUUID[SwapUUID(uuid)])
+}
+
+func (this *Index) GetByMAC(mac string) *Machine {
+	n, err := NormalizeMAC(mac)
+	if err != nil {
+		return nil
+	}
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.owner(this.byMAC[n])
+}
+
+func (this *Index) owner(claims []resources.ObjectName) *Machine {
+	if len(claims) == 0 {
+		return nil
+	}
+	return this.elements[claims[0].String()]
+}
+
+// Set adds or updates a machine. It returns the names of other machines
+// whose conflict state might have been changed.
+func (this *Index) Set(m *Machine) kipxe.NameSet {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	affected := kipxe.NameSet{}
+	old := this.elements[m.Name.String()]
+	oldUUID, oldMACs := keys(old)
+	newUUID, newMACs := keys(m)
+
+	for k := range oldUUID {
+		if !newUUID[k] {
+			affected.AddSet(unclaim(this.byUUID, k, m.Name))
+		}
+	}
+	for k := range oldMACs {
+		if !newMACs[k] {
+			affected.AddSet(unclaim(this.byMAC, k, m.Name))
+		}
+	}
+	this.elements[m.Name.String()] = m
+	reorder := old != nil && !old.Created.Equal(m.Created)
+	for k := range newUUID {
+		if !oldUUID[k] {
+			affected.AddSet(this.claim(this.byUUID, k, m.Name))
+		} else if reorder {
+			affected.AddSet(this.order(this.byUUID, k, m.Name))
+		}
+	}
+	for k := range newMACs {
+		if !oldMACs[k] {
+			affected.AddSet(this.claim(this.byMAC, k, m.Name))
+		} else if reorder {
+			affected.AddSet(this.order(this.byMAC, k, m.Name))
+		}
+	}
+	return affected
+}
+
+// Delete removes a machine. It returns the names of other machines
+// whose conflict state might have been changed.
+func (this *Index) Delete(name resources.ObjectName) kipxe.NameSet {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	affected := kipxe.NameSet{}
+	old := this.elements[name.String()]
+	if old == nil {
+		return affected
+	}
+	uuids, macs := keys(old)
+	for k := range uuids {
+		affected.AddSet(unclaim(this.byUUID, k, name))
+	}
+	for k := range macs {
+		affected.AddSet(unclaim(this.byMAC, k, name))
+	}
+	delete(this.elements, name.String())
+	return affected
+}
+
+// Conflicts describes the UUID and MAC addresses of a machine
+// which are owned by other machines.
+func (this *Index) Conflicts(name resources.ObjectName) []string {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	var conflicts []string
+	m := this.elements[name.String()]
+	if m == nil {
+		return nil
+	}
+	uuids, macs := keys(m)
+	for k := range uuids {
+		if c := this.byUUID[k]; len(c) > 0 && !resources.EqualsObjectName(c[0], name) {
+			conflicts = append(conflicts, fmt.Sprintf("uuid %s used by %s", k, c[0]))
+		}
+	}
+	for k := range macs {
+		if c := this.byMAC[k]; len(c) > 0 && !resources.EqualsObjectName(c[0], name) {
+			conflicts = append(conflicts, fmt.Sprintf("mac %s used by %s", k, c[0]))
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+func keys(m *Machine) (map[string]bool, map[string]bool) {
+	uuids := map[string]bool{}
+	macs := map[string]bool{}
+	if m != nil {
+		if m.UUID != "" {
+			uuids[m.UUID] = true
+		}
+		for _, mac := range m.AllMACs() {
+			macs[mac] = true
+		}
+	}
+	return uuids, macs
+}
+
+// claim adds a claim for a key and provides the other claimants.
+func (this *Index) claim(index map[string][]resources.ObjectName, key string, name resources.ObjectName) kipxe.NameSet {
+	index[key] = append(index[key], name)
+	return this.order(index, key, name)
+}
+
+// order sorts the claims for a key by the age of the claiming machines
+// and provides the other claimants.
+func (this *Index) order(index map[string][]resources.ObjectName, key string, name resources.ObjectName) kipxe.NameSet {
+	claims := index[key]
+	sort.SliceStable(claims, func(i, j int) bool {
+		return this.older(claims[i], claims[j])
+	})
+	return claimants(claims, name)
+}
+
+// older checks whether machine a has precedence over machine b.
+func (this *Index) older(a, b resources.ObjectName) bool {
+	ma, mb := this.elements[a.String()], this.elements[b.String()]
+	if ma != nil && mb != nil && !ma.Created.Equal(mb.Created) {
+		return ma.Created.Before(mb.Created)
+	}
+	return a.String() < b.String()
+}
+
+// unclaim removes a claim for a key and provides the other claimants.
+func unclaim(index map[string][]resources.ObjectName, key string, name resources.ObjectName) kipxe.NameSet {
+	claims := index[key]
+	for i, c := range claims {
+		if resources.EqualsObjectName(c, name) {
+			claims = append(claims[:i:i], claims[i+1:]...)
+			break
+		}
+	}
+	if len(claims) == 0 {
+		delete(index, key)
+	} else {
+		index[key] = claims
+	}
+	return claimants(claims, name)
+}
+
+func claimants(claims []resources.ObjectName, name resources.ObjectName) kipxe.NameSet {
+	set := kipxe.NameSet{}
+	for _, c := range claims {
+		if !resources.EqualsObjectName(c, name) {
+			set.Add(c)
+		}
+	}
+	return set
+}
+
+// String provides a short description of the index content.
+func (this *Index) String() string {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return strings.Join([]string{
+		fmt.Sprintf("%d machines", len(this.elements)),
+		fmt.Sprintf("%d uuids", len(this.byUUID)),
+		fmt.Sprintf("%d macs", len(this.byMAC)),
+	}, ", ")
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package machines
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/resources"
+	"github.com/gardener/controller-manager-library/pkg/types"
+	"github.com/gardener/controller-manager-library/pkg/types/infodata/simple"
+
+	"github.com/mandelsoft/kipxe/pkg/apis/ipxe/v1alpha1"
+)
+
+// Machine is the validated and normalized content of a kipxe
+// Machine resource.
+type Machine struct {
+	Name       resources.ObjectName
+	UUID       string
+	MACs       map[string][]string
+	Values     simple.Values
+	Additional simple.Values
+	Labels     map[string]string
+	Discovered bool
+	Created    time.Time
+}
+
+// NewMachine validates a machine resource. Additionally it reports
+// duplicate MAC addresses found in the specification.
+func NewMachine(m *v1alpha1.Machine) (*Machine, []string, error) {
+	var duplicates []string
+
+	macs := map[string][]string{}
+	found := map[string]string{}
+	for _, purpose := range sortedPurposes(m.Spec.MACs) {
+		list := []string{}
+		for _, mac := range m.Spec.MACs[purpose] {
+			n, err := NormalizeMAC(mac)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid mac %q for purpose %q: %s", mac, purpose, err)
+			}
+			if p, ok := found[n]; ok {
+				duplicates = append(duplicates, fmt.Sprintf("mac %s used for purposes %q and %q", n, p, purpose))
+				if p == purpose {
+					continue
+				}
+			} else {
+				found[n] = purpose
+			}
+			list = append(list, n)
+		}
+		macs[purpose] = list
+	}
+	if m.Spec.UUID == "" && len(found) == 0 {
+		return nil, nil, fmt.Errorf("uuid or at least one mac required")
+	}
+	values := simple.Values{}
+	if m.Spec.Values.Values != nil {
+		values = types.NormValues(m.Spec.Values.Values)
+	}
+	additional := simple.Values{}
+	if m.Spec.Additional.Values != nil {
+		additional = types.NormValues(m.Spec.Additional.Values)
+	}
+	labels := map[string]string{}
+	for k, v := range m.Labels {
+		labels[k] = v
+	}
+	return &Machine{
+		Name:       resources.NewObjectName(m.Namespace, m.Name),
+		UUID:       NormalizeUUID(m.Spec.UUID),
+		MACs:       macs,
+		Values:     values,
+		Additional: additional,
+		Labels:     labels,
+		Discovered: labels[v1alpha1.LABEL_DISCOVERED] == "true",
+		Created:    m.CreationTimestamp.Time,
+	}, duplicates, nil
+}
+
+// AllMACs provides all MAC addresses of the machine.
+func (this *Machine) AllMACs() []string {
+	found := map[string]bool{}
+	result := []string{}
+	for _, purpose := range sortedPurposes(this.MACs) {
+		for _, mac := range this.MACs[purpose] {
+			if !found[mac] {
+				found[mac] = true
+				result = append(result, mac)
+			}
+		}
+	}
+	return result
+}
+
+func sortedPurposes(macs map[string][]string) []string {
+	keys := []string{}
+	for k := range macs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package machines
+
+import (
+	"net/http"
+
+	"github.com/gardener/controller-manager-library/pkg/convert"
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/gardener/controller-manager-library/pkg/types"
+
+	"github.com/mandelsoft/kipxe/pkg/kipxe"
+)
+
+// Mapper is a metadata mapper enriching the request metadata
+// by the content of a Machine found in an Index.
+type Mapper struct {
+	index  *Index
+	weight int
+}
+
+var _ kipxe.MetaDataMapper = &Mapper{}
+
+func NewMapper(index *Index, weight int) *Mapper {
+	return &Mapper{index, weight}
+}
+
+func (this *Mapper) Weight() int {
+	return this.weight
+}
+
+func (this *Mapper) String() string {
+	return "machine mapper"
+}
+
+func (this *Mapper) Lookup(values kipxe.MetaData) *Machine {
+	if uuid, ok := values["uuid"].(string); ok && uuid != "" {
+		if m := this.index.GetByUUID(uuid); m != nil {
+			return m
+		}
+	}
+	if macs, ok := values["__mac__"].([]interface{}); ok {
+		for _, v := range macs {
+			if mac, ok := v.(string); ok {
+				if m := this.index.GetByMAC(mac); m != nil {
+					return m
+				}
+			}
+		}
+	} else if mac, ok := values["mac"].(string); ok {
+		return this.index.GetByMAC(mac)
+	}
+	return nil
+}
+
+func (this *Mapper) Map(logger logger.LogContext, values kipxe.MetaData, req *http.Request) (kipxe.MetaData, error) {
+	if convert.BestEffortBool(values[kipxe.MACHINE_FOUND]) {
+		return values, nil
+	}
+	m := this.Lookup(values)
+	if m == nil {
+		logger.Infof("no machine found")
+		return values, nil
+	}
+	values = values.DeepCopy()
+	for k, v := range m.Values {
+		values[k] = types.CopyAndNormalize(v)
+	}
+	if m.UUID != "" {
+		values["uuid"] = m.UUID
+	}
+	macs := []interface{}{}
+	for _, mac := range m.AllMACs() {
+		macs = append(macs, mac)
+	}
+	values["macs"] = macs
+	purposes := map[string]interface{}{}
+	for p, list := range m.MACs {
+		macs := []interface{}{}
+		for _, mac := range list {
+			macs = append(macs, mac)
+		}
+		purposes[p] = macs
+	}
+	values["macsbypurpose"] = purposes
+	values["additional"] = types.CopyAndNormalize(map[string]interface{}(m.Additional))
+	labels := map[string]interface{}{}
+	for k, v := range m.Labels {
+		labels[k] = v
+	}
+	values["labels"] = labels
+	if m.Discovered {
+		values["discovered"] = "true"
+	}
+	values["machine-name"] = m.Name.String()
+	values[kipxe.MACHINE_FOUND] = true
+	logger.Infof("found machine %s", m.Name)
+	return values, nil
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package machines
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// NormalizeMAC provides the canonical representation of a MAC address
+// (lower case, colon separated). Besides the formats supported by
+// net.ParseMAC plain hex strings (001122334455) are accepted.
+func NormalizeMAC(mac string) (string, error) {
+	mac = strings.TrimSpace(mac)
+	if len(mac) == 12 && isHex(mac) {
+		mac = strings.Join([]string{mac[0:2], mac[2:4], mac[4:6], mac[6:8], mac[8:10], mac[10:12]}, ":")
+	}
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return "", err
+	}
+	return hw.String(), nil
+}
+
+// NormalizeUUID provides the canonical representation of a UUID
+// (lower case, 8-4-4-4-12 hex digits). Braces, an urn:uuid: prefix
+// and missing or misplaced dashes are tolerated. Strings not
+// representing a UUID are just trimmed and converted to lower case.
+func NormalizeUUID(uuid string) string {
+	uuid = strings.ToLower(strings.TrimSpace(uuid))
+	uuid = strings.TrimPrefix(uuid, "urn:uuid:")
+	uuid = strings.TrimSuffix(strings.TrimPrefix(uuid, "{"), "}")
+	hex := strings.ReplaceAll(uuid, "-", "")
+	if len(hex) != 32 || !isHex(hex) {
+		return uuid
+	}
+	return fmt.Sprintf("%s-%s-%s-%s-%s", hex[0:8], hex[8:12], hex[12:16], hex[16:20], hex[20:32])
+}
+
+// SwapUUID converts a normalized UUID between the big endian (RFC 4122)
+// and the mixed endian byte order used by SMBIOS for the first three fields.
+// Firmwares differ in the representation they report, so lookups should
+// try both variants. An empty string is returned for non-canonical UUIDs.
+func SwapUUID(uuid string) string {
+	if !IsCanonicalUUID(uuid) {
+		return ""
+	}
+	return swap(uuid[0:8]) + "-" + swap(uuid[9:13]) + "-" + swap(uuid[14:18]) + uuid[18:]
+}
+
+// IsCanonicalUUID checks whether a string is a normalized UUID.
+func IsCanonicalUUID(uuid string) bool {
+	return len(uuid) == 36 && NormalizeUUID(uuid) == uuid && isHex(strings.ReplaceAll(uuid, "-", ""))
+}
+
+func swap(hex string) string {
+	r := ""
+	for i := len(hex); i > 0; i -= 2 {
+		r += hex[i-2 : i]
+	}
+	return r
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,317 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package manifests
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gardener/controller-manager-library/pkg/logger"
+	"github.com/gardener/controller-manager-library/pkg/resources"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/mandelsoft/kipxe/pkg/controllers/ipxe"
+	"github.com/mandelsoft/kipxe/pkg/kipxe"
+)
+
+// RELOAD_DELAY is the delay used to collect file system events
+// before the manifests are reloaded.
+const RELOAD_DELAY = 500 * time.Millisecond
+
+// Backend feeds an info base with the manifests found in a set of
+// files or directories and keeps it up to date with the file system.
+// This allows to use kipxe without a Kubernetes cluster.
+// Referenced config maps and secrets are always taken from the actual
+// manifest set.
+type Backend struct {
+	logger   logger.LogContext
+	paths    []string
+	cache    kipxe.Cache
+	infobase *kipxe.InfoBase
+
+	reload  sync.Mutex
+	lock    sync.RWMutex
+	current *Manifests
+	mappers map[string]kipxe.MetaDataMapper
+}
+
+// NewBackend creates a backend for the given manifest files or
+// directories and loads the initial manifest set. Invalid elements
+// are reported by an error of type Errors together with the backend.
+func NewBackend(logger logger.LogContext, cache kipxe.Cache, paths ...string) (*Backend, error) {
+	resc := kipxe.NewResources()
+	profiles := kipxe.NewProfiles(resc)
+	this := &Backend{
+		logger: logger,
+		paths:  paths,
+		cache:  cache,
+		infobase: &kipxe.InfoBase{
+			Registry:  kipxe.NewRegistry(),
+			Resources: resc,
+			Profiles:  profiles,
+			Matchers:  kipxe.NewMatchers(profiles),
+		},
+		current: New(),
+		mappers: map[string]kipxe.MetaDataMapper{},
+	}
+	err := this.Reload()
+	if _, ok := err.(Errors); err != nil && !ok {
+		return nil, err
+	}
+	return this, err
+}
+
+func (this *Backend) InfoBase() *kipxe.InfoBase {
+	return this.infobase
+}
+
+func (this *Backend) load() (*Manifests, error) {
+	m := New()
+	for _, p := range this.paths {
+		if err := m.Load(p); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Reload reads the manifests again and applies all changes to the
+// info base. If the manifests cannot be read, the actual state is kept.
+// Invalid elements are removed from the info base and reported by
+// the returned error.
+func (this *Backend) Reload() error {
+	this.reload.Lock()
+	defer this.reload.Unlock()
+
+	m, err := this.load()
+	if err != nil {
+		return fmt.Errorf("cannot load manifests: %s", err)
+	}
+
+	this.lock.Lock()
+	old := this.current
+	this.current = m
+	this.lock.Unlock()
+
+	return this.apply(old, m)
+}
+
+func (this *Backend) apply(old, m *Manifests) error {
+	var errs Errors
+
+	for _, k := range sortedKeys(m.Resources) {
+		if reflect.DeepEqual(old.Resources[k], m.Resources[k]) {
+			continue
+		}
+		e, err := ipxe.NewResourceForSpec(m.Resources[k], this.cache, this.ConfigMapGetter(), this.SecretGetter())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("resource %s: %s", k, err))
+			delete(m.Resources, k)
+			continue
+		}
+		this.logger.Infof("update resource %s", k)
+		this.infobase.SetDocument(e)
+	}
+	for _, k := range sortedKeys(m.Profiles) {
+		if reflect.DeepEqual(old.Profiles[k], m.Profiles[k]) {
+			continue
+		}
+		e, err := ipxe.NewProfile(m.Profiles[k])
+		if err == nil {
+			this.logger.Infof("update profile %s", k)
+			_, err = this.infobase.SetProfile(e)
+		} else {
+			delete(m.Profiles, k)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("profile %s: %s", k, err))
+		}
+	}
+	for _, k := range sortedKeys(m.Matchers) {
+		if reflect.DeepEqual(old.Matchers[k], m.Matchers[k]) {
+			continue
+		}
+		e, err := ipxe.NewMatcher(m.Matchers[k])
+		if err == nil {
+			this.logger.Infof("update matcher %s", k)
+			err = this.infobase.SetMatcher(e)
+		} else {
+			delete(m.Matchers, k)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("matcher %s: %s", k, err))
+		}
+	}
+	for _, k := range sortedKeys(m.Mappers) {
+		if reflect.DeepEqual(old.Mappers[k], m.Mappers[k]) {
+			continue
+		}
+		e, err := ipxe.NewMapper(m.Mappers[k])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("mapper %s: %s", k, err))
+			delete(m.Mappers, k)
+			continue
+		}
+		this.logger.Infof("update mapper %s", k)
+		this.infobase.Registry.SwitchRegistration(this.mappers[k], e)
+		this.mappers[k] = e
+	}
+
+	for _, k := range sortedKeys(old.Matchers) {
+		if m.Matchers[k] == nil {
+			this.logger.Infof("delete matcher %s", k)
+			this.infobase.Matchers.Delete(resources.NewObjectNameForData(old.Matchers[k]))
+		}
+	}
+	for _, k := range sortedKeys(old.Profiles) {
+		if m.Profiles[k] == nil {
+			this.logger.Infof("delete profile %s", k)
+			this.infobase.Matchers.Recheck(this.infobase.Profiles.Delete(resources.NewObjectNameForData(old.Profiles[k])))
+		}
+	}
+	for _, k := range sortedKeys(old.Resources) {
+		if m.Resources[k] == nil {
+			this.logger.Infof("delete resource %s", k)
+			users := this.infobase.Resources.Delete(resources.NewObjectNameForData(old.Resources[k]))
+			this.infobase.Matchers.Recheck(this.infobase.Profiles.Recheck(users))
+		}
+	}
+	for _, k := range sortedKeys(old.Mappers) {
+		if m.Mappers[k] == nil {
+			this.logger.Infof("delete mapper %s", k)
+			this.infobase.Registry.Unregister(this.mappers[k])
+			delete(this.mappers, k)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ConfigMapGetter provides access to the config maps of the actual manifest set.
+func (this *Backend) ConfigMapGetter() ipxe.ObjectGetter {
+	return func(name resources.ObjectName) (runtime.Object, error) {
+		this.lock.RLock()
+		defer this.lock.RUnlock()
+		if o := this.current.ConfigMaps[name.String()]; o != nil {
+			return o, nil
+		}
+		return nil, errors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, name.String())
+	}
+}
+
+// SecretGetter provides access to the secrets of the actual manifest set.
+func (this *Backend) SecretGetter() ipxe.ObjectGetter {
+	return func(name resources.ObjectName) (runtime.Object, error) {
+		this.lock.RLock()
+		defer this.lock.RUnlock()
+		if o := this.current.Secrets[name.String()]; o != nil {
+			return o, nil
+		}
+		return nil, errors.NewNotFound(schema.GroupResource{Resource: "secrets"}, name.String())
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Watch watches the manifest files and directories and reloads
+// the manifests on changes until the context is cancelled.
+func (this *Backend) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := this.watch(watcher); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(RELOAD_DELAY)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			this.logger.Debugf("%s", event)
+			timer.Reset(RELOAD_DELAY)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			this.logger.Errorf("watch failed: %s", err)
+		case <-timer.C:
+			if err := this.watch(watcher); err != nil {
+				this.logger.Errorf("%s", err)
+			}
+			this.logger.Infof("reloading manifests")
+			if err := this.Reload(); err != nil {
+				this.logger.Errorf("%s", err)
+			}
+		}
+	}
+}
+
+// watch adds all directories of the manifest paths to the watcher.
+// Files are watched by their directory to catch replacements.
+func (this *Backend) watch(watcher *fsnotify.Watcher) error {
+	for _, p := range this.paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			if err := watcher.Add(filepath.Dir(p)); err != nil {
+				return err
+			}
+			continue
+		}
+		err = filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return watcher.Add(path)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
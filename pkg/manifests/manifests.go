@@ -0,0 +1,302 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package manifests
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gardener/controller-manager-library/pkg/resources"
+	"github.com/ghodss/yaml"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kyaml "k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/mandelsoft/kipxe/pkg/apis/ipxe/v1alpha1"
+	"github.com/mandelsoft/kipxe/pkg/controllers/ipxe"
+	"github.com/mandelsoft/kipxe/pkg/kipxe"
+)
+
+const DEFAULT_NAMESPACE = "default"
+
+// Manifests is a set of kipxe objects (and referenced config maps and secrets)
+// read from manifest files. It can be used to feed a kipxe.InfoBase without
+// a Kubernetes cluster.
+type Manifests struct {
+	Matchers   map[string]*v1alpha1.BootProfileMatcher
+	Profiles   map[string]*v1alpha1.BootProfile
+	Resources  map[string]*v1alpha1.BootResource
+	Mappers    map[string]*v1alpha1.MetaDataMapper
+	ConfigMaps map[string]*v1.ConfigMap
+	Secrets    map[string]*v1.Secret
+}
+
+func New() *Manifests {
+	return &Manifests{
+		Matchers:   map[string]*v1alpha1.BootProfileMatcher{},
+		Profiles:   map[string]*v1alpha1.BootProfile{},
+		Resources:  map[string]*v1alpha1.BootResource{},
+		Mappers:    map[string]*v1alpha1.MetaDataMapper{},
+		ConfigMaps: map[string]*v1.ConfigMap{},
+		Secrets:    map[string]*v1.Secret{},
+	}
+}
+
+func IsManifestFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// Load reads a manifest file or all manifest files
+// (.yaml, .yml or .json) found in a directory tree.
+func (this *Manifests) Load(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return this.LoadFile(path)
+	}
+	return filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !IsManifestFile(path) {
+			return nil
+		}
+		return this.LoadFile(path)
+	})
+}
+
+func (this *Manifests) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return this.Parse(path, data)
+}
+
+// Parse parses a (multi document) manifest and adds the found objects.
+func (this *Manifests) Parse(name string, data []byte) error {
+	reader := kyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	for i := 1; ; i++ {
+		doc, err := reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("%s: document %d: %s", name, i, err)
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		if err := this.parseDocument(doc); err != nil {
+			return fmt.Errorf("%s: document %d: %s", name, i, err)
+		}
+	}
+}
+
+func (this *Manifests) parseDocument(doc []byte) error {
+	meta := &metav1.TypeMeta{}
+	if err := yaml.Unmarshal(doc, meta); err != nil {
+		return err
+	}
+	if meta.Kind == "" {
+		// comment only document
+		return nil
+	}
+	gk := meta.GroupVersionKind().GroupKind()
+	var obj runtime.Object
+	switch gk {
+	case v1alpha1.MATCHER:
+		obj = &v1alpha1.BootProfileMatcher{}
+	case v1alpha1.PROFILE:
+		obj = &v1alpha1.BootProfile{}
+	case v1alpha1.RESOURCE:
+		obj = &v1alpha1.BootResource{}
+	case v1alpha1.METADATAMAPPER:
+		obj = &v1alpha1.MetaDataMapper{}
+	case schema.GroupKind{Kind: "ConfigMap"}:
+		obj = &v1.ConfigMap{}
+	case schema.GroupKind{Kind: "Secret"}:
+		obj = &v1.Secret{}
+	default:
+		return fmt.Errorf("unsupported kind %s", gk)
+	}
+	if err := yaml.Unmarshal(doc, obj); err != nil {
+		return err
+	}
+	this.Add(obj)
+	return nil
+}
+
+func key(meta *metav1.ObjectMeta) string {
+	if meta.Namespace == "" {
+		meta.Namespace = DEFAULT_NAMESPACE
+	}
+	return resources.NewObjectName(meta.Namespace, meta.Name).String()
+}
+
+// Add adds an object. Already existing objects with the same name
+// are replaced.
+func (this *Manifests) Add(obj runtime.Object) {
+	switch o := obj.(type) {
+	case *v1alpha1.BootProfileMatcher:
+		this.Matchers[key(&o.ObjectMeta)] = o
+	case *v1alpha1.BootProfile:
+		this.Profiles[key(&o.ObjectMeta)] = o
+	case *v1alpha1.BootResource:
+		this.Resources[key(&o.ObjectMeta)] = o
+	case *v1alpha1.MetaDataMapper:
+		this.Mappers[key(&o.ObjectMeta)] = o
+	case *v1.ConfigMap:
+		this.ConfigMaps[key(&o.ObjectMeta)] = o
+	case *v1.Secret:
+		this.Secrets[key(&o.ObjectMeta)] = o
+	}
+}
+
+// ConfigMapGetter provides access to the config maps of the manifest set.
+func (this *Manifests) ConfigMapGetter() ipxe.ObjectGetter {
+	return func(name resources.ObjectName) (runtime.Object, error) {
+		if o := this.ConfigMaps[name.String()]; o != nil {
+			return o, nil
+		}
+		return nil, errors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, name.String())
+	}
+}
+
+// SecretGetter provides access to the secrets of the manifest set.
+func (this *Manifests) SecretGetter() ipxe.ObjectGetter {
+	return func(name resources.ObjectName) (runtime.Object, error) {
+		if o := this.Secrets[name.String()]; o != nil {
+			return o, nil
+		}
+		return nil, errors.NewNotFound(schema.GroupResource{Resource: "secrets"}, name.String())
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Errors is a list of errors found for a manifest set.
+type Errors []error
+
+func (this Errors) Error() string {
+	msgs := []string{}
+	for _, e := range this {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	switch v := m.(type) {
+	case map[string]*v1alpha1.BootProfileMatcher:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]*v1alpha1.BootProfile:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]*v1alpha1.BootResource:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]*v1alpha1.MetaDataMapper:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// InfoBase creates a new info base for the manifest set.
+// All invalid elements are reported by the returned error.
+// The info base is usable even if errors are reported.
+func (this *Manifests) InfoBase(cache kipxe.Cache) (*kipxe.InfoBase, error) {
+	resc := kipxe.NewResources()
+	profiles := kipxe.NewProfiles(resc)
+	infobase := &kipxe.InfoBase{
+		Registry:  kipxe.NewRegistry(),
+		Resources: resc,
+		Profiles:  profiles,
+		Matchers:  kipxe.NewMatchers(profiles),
+	}
+	return infobase, this.Apply(infobase, cache)
+}
+
+// Apply applies the manifest set to an info base.
+func (this *Manifests) Apply(infobase *kipxe.InfoBase, cache kipxe.Cache) error {
+	var errs Errors
+
+	for _, k := range sortedKeys(this.Resources) {
+		e, err := ipxe.NewResourceForSpec(this.Resources[k], cache, this.ConfigMapGetter(), this.SecretGetter())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("resource %s: %s", k, err))
+			continue
+		}
+		infobase.SetDocument(e)
+	}
+	for _, k := range sortedKeys(this.Profiles) {
+		e, err := ipxe.NewProfile(this.Profiles[k])
+		if err == nil {
+			_, err = infobase.SetProfile(e)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("profile %s: %s", k, err))
+		}
+	}
+	for _, k := range sortedKeys(this.Matchers) {
+		e, err := ipxe.NewMatcher(this.Matchers[k])
+		if err == nil {
+			err = infobase.SetMatcher(e)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("matcher %s: %s", k, err))
+		}
+	}
+	for _, k := range sortedKeys(this.Mappers) {
+		e, err := ipxe.NewMapper(this.Mappers[k])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("mapper %s: %s", k, err))
+			continue
+		}
+		infobase.Registry.Register(e)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
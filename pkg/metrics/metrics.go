@@ -0,0 +1,146 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+// Package metrics provides the labeled counters and histograms used by
+// kipxe on top of the Prometheus client library. In contrast to the client
+// library, samples with an invalid number of label values are dropped
+// instead of panicking, because they are recorded while serving requests.
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefBuckets are the default histogram buckets for latencies in seconds.
+var DefBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+}
+
+// DefaultRegistry is the registry used by the package level functions.
+func DefaultRegistry() *prometheus.Registry {
+	return registry
+}
+
+func MustRegister(c ...prometheus.Collector) {
+	registry.MustRegister(c...)
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// CounterVec is a set of counters partitioned by label values.
+type CounterVec struct {
+	*prometheus.CounterVec
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels),
+	}
+	if len(labels) == 0 {
+		// unpartitioned counters are always reported
+		c.Add(0)
+	}
+	return c
+}
+
+func (this *CounterVec) Inc(labels ...string) {
+	this.Add(1, labels...)
+}
+
+func (this *CounterVec) Add(v float64, labels ...string) {
+	if c, err := this.GetMetricWithLabelValues(labels...); err == nil {
+		c.Add(v)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// HistogramVec is a set of histograms partitioned by label values.
+type HistogramVec struct {
+	*prometheus.HistogramVec
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	h := &HistogramVec{
+		prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}, labels),
+	}
+	if len(labels) == 0 {
+		h.GetMetricWithLabelValues()
+	}
+	return h
+}
+
+func (this *HistogramVec) Observe(v float64, labels ...string) {
+	if o, err := this.GetMetricWithLabelValues(labels...); err == nil {
+		o.Observe(v)
+	}
+}
+
+// ObserveDuration observes the time elapsed since start in seconds.
+func (this *HistogramVec) ObserveDuration(start time.Time, labels ...string) {
+	this.Observe(time.Since(start).Seconds(), labels...)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+const OTHER = "other"
+
+// LabelGuard limits the cardinality of a label.
+// After the maximum number of distinct values has been reached,
+// all new values are mapped to OTHER.
+type LabelGuard struct {
+	lock   sync.Mutex
+	max    int
+	values map[string]struct{}
+}
+
+func NewLabelGuard(max int) *LabelGuard {
+	return &LabelGuard{max: max, values: map[string]struct{}{}}
+}
+
+func (this *LabelGuard) Value(v string) string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.values[v]; ok {
+		return v
+	}
+	if len(this.values) >= this.max {
+		return OTHER
+	}
+	this.values[v] = struct{}{}
+	return v
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package tftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	OP_RRQ   = 1
+	OP_WRQ   = 2
+	OP_DATA  = 3
+	OP_ACK   = 4
+	OP_ERROR = 5
+	OP_OACK  = 6
+)
+
+const (
+	ERR_UNDEFINED      = 0
+	ERR_NOT_FOUND      = 1
+	ERR_ACCESS         = 2
+	ERR_DISK_FULL      = 3
+	ERR_ILLEGAL_OP     = 4
+	ERR_UNKNOWN_TID    = 5
+	ERR_FILE_EXISTS    = 6
+	ERR_NO_SUCH_USER   = 7
+	ERR_OPTION_REFUSED = 8
+)
+
+const OPT_BLKSIZE = "blksize"
+const OPT_TSIZE = "tsize"
+const OPT_TIMEOUT = "timeout"
+
+const DEFAULT_BLKSIZE = 512
+const MIN_BLKSIZE = 8
+const MAX_BLKSIZE = 65464
+
+// Request is a parsed read or write request (RFC 1350)
+// including the requested options (RFC 2347).
+type Request struct {
+	Opcode   uint16
+	Filename string
+	Mode     string
+	Options  map[string]string
+	// Order keeps the order of the requested options
+	Order []string
+}
+
+func ParseRequest(data []byte) (*Request, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("packet too short")
+	}
+	op := binary.BigEndian.Uint16(data)
+	if op != OP_RRQ && op != OP_WRQ {
+		return nil, fmt.Errorf("unexpected opcode %d", op)
+	}
+	fields := bytes.Split(data[2:], []byte{0})
+	if len(fields) < 3 || len(fields[len(fields)-1]) != 0 {
+		return nil, fmt.Errorf("malformed request")
+	}
+	fields = fields[:len(fields)-1]
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("malformed request options")
+	}
+	req := &Request{
+		Opcode:   op,
+		Filename: string(fields[0]),
+		Mode:     strings.ToLower(string(fields[1])),
+		Options:  map[string]string{},
+	}
+	for i := 2; i < len(fields); i += 2 {
+		name := strings.ToLower(string(fields[i]))
+		if _, ok := req.Options[name]; !ok {
+			req.Order = append(req.Order, name)
+		}
+		req.Options[name] = string(fields[i+1])
+	}
+	return req, nil
+}
+
+func DataPacket(block uint16, data []byte) []byte {
+	buf := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint16(buf, OP_DATA)
+	binary.BigEndian.PutUint16(buf[2:], block)
+	copy(buf[4:], data)
+	return buf
+}
+
+func ErrorPacket(code uint16, msg string) []byte {
+	buf := make([]byte, 4, 5+len(msg))
+	binary.BigEndian.PutUint16(buf, OP_ERROR)
+	binary.BigEndian.PutUint16(buf[2:], code)
+	buf = append(buf, []byte(msg)...)
+	return append(buf, 0)
+}
+
+func OAckPacket(order []string, options map[string]string) []byte {
+	buf := make([]byte, 2)
+	binary.BigEndian.PutUint16(buf, OP_OACK)
+	for _, k := range order {
+		if v, ok := options[k]; ok {
+			buf = append(buf, []byte(k)...)
+			buf = append(buf, 0)
+			buf = append(buf, []byte(v)...)
+			buf = append(buf, 0)
+		}
+	}
+	return buf
+}
+
+// ParseAck returns the block number of an ACK packet.
+// For an ERROR packet an error is returned.
+func ParseAck(data []byte) (uint16, error) {
+	if len(data) < 4 {
+		return 0, fmt.Errorf("packet too short")
+	}
+	switch binary.BigEndian.Uint16(data) {
+	case OP_ACK:
+		return binary.BigEndian.Uint16(data[2:]), nil
+	case OP_ERROR:
+		msg := string(bytes.TrimRight(data[4:], "\x00"))
+		return 0, fmt.Errorf("client error %d: %s", binary.BigEndian.Uint16(data[2:]), msg)
+	default:
+		return 0, fmt.Errorf("unexpected opcode %d", binary.BigEndian.Uint16(data))
+	}
+}
@@ -0,0 +1,302 @@
+/*
+ * Copyright 2020 Mandelsoft. All rights reserved.
+ *  This file is licensed under the Apache Software License, v. 2 except as noted
+ *  otherwise in the LICENSE file
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gardener/controller-manager-library/pkg/ctxutil"
+	"github.com/gardener/controller-manager-library/pkg/logger"
+)
+
+// Handler provides the content for a requested file name.
+// Errors of type *Error are passed to the client with their
+// error code, all other errors are reported as undefined error.
+type Handler interface {
+	ReadFile(logger logger.LogContext, filename string, remote *net.UDPAddr) ([]byte, error)
+}
+
+type HandlerFunc func(logger logger.LogContext, filename string, remote *net.UDPAddr) ([]byte, error)
+
+func (this HandlerFunc) ReadFile(logger logger.LogContext, filename string, remote *net.UDPAddr) ([]byte, error) {
+	return this(logger, filename, remote)
+}
+
+type Error struct {
+	Code uint16
+	Msg  string
+}
+
+func NewError(code uint16, msg string, args ...interface{}) error {
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	return &Error{code, msg}
+}
+
+func (this *Error) Error() string {
+	return this.Msg
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Server is a read-only TFTP server according to RFC 1350 supporting
+// the option extension (RFC 2347) with the options blksize (RFC 2348),
+// timeout and tsize (RFC 2349).
+type Server struct {
+	logger.LogContext
+	ctx     context.Context
+	name    string
+	handler Handler
+	ip      net.IP
+
+	Timeout time.Duration
+	Retries int
+}
+
+func NewServer(ctx context.Context, logger logger.LogContext, name string, handler Handler) *Server {
+	return &Server{
+		LogContext: logger,
+		ctx:        ctx,
+		name:       name,
+		handler:    handler,
+		Timeout:    3 * time.Second,
+		Retries:    5,
+	}
+}
+
+// Start starts the TFTP server on the given address and port.
+// It is stopped when the context of the server is done.
+func (this *Server) Start(bindAddress string, port int) error {
+	listenAddress := fmt.Sprintf("%s:%d", bindAddress, port)
+	addr, err := net.ResolveUDPAddr("udp", listenAddress)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	this.ip = addr.IP
+	this.Infof("starting %s as tftp server (serving on %s)", this.name, listenAddress)
+
+	ctxutil.WaitGroupAdd(this.ctx)
+	go func() {
+		<-this.ctx.Done()
+		this.Infof("shutting down server %q", this.name)
+		conn.Close()
+	}()
+
+	go func() {
+		defer ctxutil.WaitGroupDone(this.ctx)
+		buf := make([]byte, 65536)
+		for {
+			n, remote, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				select {
+				case <-this.ctx.Done():
+					this.Infof("server %q stopped", this.name)
+					return
+				default:
+				}
+				this.Errorf("tftp read failed: %s", err)
+				continue
+			}
+			req, err := ParseRequest(buf[:n])
+			if err != nil {
+				this.Warnf("invalid tftp request from %s: %s", remote, err)
+				conn.WriteToUDP(ErrorPacket(ERR_ILLEGAL_OP, err.Error()), remote)
+				continue
+			}
+			go this.transfer(req, remote)
+		}
+	}()
+	return nil
+}
+
+func (this *Server) transfer(req *Request, remote *net.UDPAddr) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: this.ip})
+	if err != nil {
+		this.Errorf("cannot open transfer socket for %s: %s", remote, err)
+		return
+	}
+	defer conn.Close()
+
+	t := &transfer{
+		LogContext: this.LogContext,
+		conn:       conn,
+		remote:     remote,
+		timeout:    this.Timeout,
+		retries:    this.Retries,
+	}
+
+	if req.Opcode != OP_RRQ {
+		t.error(ERR_ACCESS, "read-only server")
+		return
+	}
+	if req.Mode != "octet" && req.Mode != "netascii" {
+		t.error(ERR_ILLEGAL_OP, "unsupported transfer mode %q", req.Mode)
+		return
+	}
+
+	this.Infof("tftp request %q from %s", req.Filename, remote)
+	data, err := this.handler.ReadFile(this.LogContext, req.Filename, remote)
+	if err != nil {
+		this.Infof("tftp request %q from %s failed: %s", req.Filename, remote, err)
+		if e, ok := err.(*Error); ok {
+			t.error(e.Code, "%s", e.Msg)
+		} else {
+			t.error(ERR_UNDEFINED, "%s", err)
+		}
+		return
+	}
+	if req.Mode == "netascii" {
+		data = toNetASCII(data)
+	}
+	err = t.send(req, data)
+	if err != nil {
+		this.Warnf("tftp transfer of %q to %s failed: %s", req.Filename, remote, err)
+	} else {
+		this.Infof("tftp transfer of %q (%d bytes) to %s done", req.Filename, len(data), remote)
+	}
+}
+
+func toNetASCII(data []byte) []byte {
+	buf := &bytes.Buffer{}
+	for _, b := range data {
+		switch b {
+		case '\n':
+			buf.Write([]byte{'\r', '\n'})
+		case '\r':
+			buf.Write([]byte{'\r', 0})
+		default:
+			buf.WriteByte(b)
+		}
+	}
+	return buf.Bytes()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type transfer struct {
+	logger.LogContext
+	conn    *net.UDPConn
+	remote  *net.UDPAddr
+	timeout time.Duration
+	retries int
+	blksize int
+}
+
+func (this *transfer) error(code uint16, msg string, args ...interface{}) {
+	this.conn.WriteToUDP(ErrorPacket(code, fmt.Sprintf(msg, args...)), this.remote)
+}
+
+func (this *transfer) negotiate(req *Request, size int) map[string]string {
+	accepted := map[string]string{}
+	for _, name := range req.Order {
+		value := req.Options[name]
+		switch name {
+		case OPT_BLKSIZE:
+			v, err := strconv.Atoi(value)
+			if err != nil || v < MIN_BLKSIZE {
+				continue
+			}
+			if v > MAX_BLKSIZE {
+				v = MAX_BLKSIZE
+			}
+			this.blksize = v
+			accepted[name] = strconv.Itoa(v)
+		case OPT_TSIZE:
+			accepted[name] = strconv.Itoa(size)
+		case OPT_TIMEOUT:
+			v, err := strconv.Atoi(value)
+			if err != nil || v < 1 || v > 255 {
+				continue
+			}
+			this.timeout = time.Duration(v) * time.Second
+			accepted[name] = value
+		}
+	}
+	return accepted
+}
+
+func (this *transfer) send(req *Request, data []byte) error {
+	this.blksize = DEFAULT_BLKSIZE
+	accepted := this.negotiate(req, len(data))
+	if len(accepted) > 0 {
+		if err := this.exchange(OAckPacket(req.Order, accepted), 0); err != nil {
+			return err
+		}
+	}
+
+	block := uint16(1)
+	for start := 0; ; block++ {
+		end := start + this.blksize
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := this.exchange(DataPacket(block, data[start:end]), block); err != nil {
+			return err
+		}
+		if end-start < this.blksize {
+			return nil
+		}
+		start = end
+	}
+}
+
+// exchange sends a packet and waits for the acknowledge of the given block.
+// The packet is retransmitted on timeout. Duplicate acknowledges are ignored
+// to avoid the Sorcerer's Apprentice Syndrome.
+func (this *transfer) exchange(packet []byte, block uint16) error {
+	buf := make([]byte, 1024)
+	for retry := 0; retry <= this.retries; retry++ {
+		if _, err := this.conn.WriteToUDP(packet, this.remote); err != nil {
+			return err
+		}
+		deadline := time.Now().Add(this.timeout)
+		for {
+			this.conn.SetReadDeadline(deadline)
+			n, addr, err := this.conn.ReadFromUDP(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return err
+			}
+			if !addr.IP.Equal(this.remote.IP) || addr.Port != this.remote.Port {
+				this.conn.WriteToUDP(ErrorPacket(ERR_UNKNOWN_TID, "unknown transfer id"), addr)
+				continue
+			}
+			ack, err := ParseAck(buf[:n])
+			if err != nil {
+				return err
+			}
+			if ack == block {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("timeout waiting for ack of block %d", block)
+}